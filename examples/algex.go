@@ -5,6 +5,8 @@ package main
 
 import (
 	"bufio"
+	"encoding/csv"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -19,13 +21,16 @@ import (
 )
 
 var (
-	tok    = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9_]*|[0-9]+|\:\=|[-+*/^=(),%]|\s*|#.*)`)
-	space  = regexp.MustCompile(`^\s+$`)
-	symbol = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`)
+	tok   = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9_]*|[0-9]+|\:\=|\*\*|[-+*/^=(),%]|\s*|#.*)`)
+	space = regexp.MustCompile(`^\s+$`)
 
 	filer = flag.String("file", "", "name of algex (.ax) script to start with")
 )
 
+// histLen is the number of prior commands and substitution states
+// remembered for history and undo.
+const histLen = 32
+
 // split tokenizes the input.
 func split(line string) (toks []string) {
 	for i := 0; i < len(line); i++ {
@@ -77,7 +82,13 @@ file <name>	take commands from a named file
 xxx := <exp>	learn a simple substitution for simplification
 xxx = <exp>	learn a simplified substitution for simplification
 list		list all of the known substitutions
+save <file>	save the known substitutions to a file
+load <file>	learn the substitutions saved in a file
+export <file>	write the known substitutions to a CSV file
 reduce <exp>    express as simple expression plus a remainder
+diff <var> <exp> differentiate an expression with respect to var
+undo		revert the last change to the known substitutions
+history		list the recent commands
 exit		exit the program
 help		this message
 <exp> mod <n>   compute modular result for expressions with a denominator of 1`)
@@ -139,10 +150,171 @@ func inline(f *terms.Frac, vars map[string]*Vars) *terms.Frac {
 	return f
 }
 
+// pointAt indicates where in its input a parsing error occurred.
+func pointAt(err error) {
+	var pe *terms.ParseError
+	if errors.As(err, &pe) {
+		fmt.Printf("  %s\n  %s^\n", pe.Input, strings.Repeat(" ", pe.Pos))
+	}
+}
+
+// remember returns undos with a copy of vars appended. At most
+// histLen states are retained.
+func remember(undos []map[string]*Vars, vars map[string]*Vars) []map[string]*Vars {
+	prev := make(map[string]*Vars)
+	for k, v := range vars {
+		prev[k] = v
+	}
+	undos = append(undos, prev)
+	if len(undos) > histLen {
+		undos = undos[1:]
+	}
+	return undos
+}
+
+// derivative differentiates f with respect to sym using the quotient
+// rule.
+func derivative(f *terms.Frac, sym factor.Value) (*terms.Frac, error) {
+	if f.Fns != nil {
+		return nil, fmt.Errorf("unable to differentiate functions in %v", f)
+	}
+	num := terms.Mul(f.Num.Derivative(sym), f.Den).Sub(terms.Mul(f.Num, f.Den.Derivative(sym)))
+	d := terms.NewFrac(num, terms.Mul(f.Den, f.Den))
+	d.Reduce()
+	return d, nil
+}
+
+// learn records the substitution implied by lhs op rhs in vars. For
+// op "=" the substitution is simplified with the known substitutions.
+func learn(vars map[string]*Vars, op string, lhs, rhs *terms.Frac) error {
+	left, right, err := terms.Rearrange(lhs, rhs)
+	if err != nil {
+		return fmt.Errorf("assignment problem: %v", err)
+	}
+	sub, ok := left.AsSubValue()
+	if !ok {
+		return fmt.Errorf("left-hand-side %q is not substitutable", left)
+	}
+	if op == "=" {
+		right = inline(right, vars)
+	} else {
+		right.Reduce()
+	}
+	v := &Vars{
+		fact:  sub,
+		subst: right,
+	}
+	if left.Fns != nil {
+		var sym string
+		for k := range left.Fns {
+			if sym != "" {
+				fmt.Printf("multiple function references in assignment: %v -> %v", left, right)
+				continue
+			}
+			sym = k
+		}
+		fn := left.Fns[sym]
+		v.fn = &fn
+	}
+	vars[left.String()] = v
+	return nil
+}
+
+// save writes the known substitutions to a file as a script of
+// "xxx := <exp>" lines.
+func save(path string, vars map[string]*Vars) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	var ts []string
+	for k := range vars {
+		ts = append(ts, k)
+	}
+	sort.Strings(ts)
+	for _, k := range ts {
+		fmt.Fprintf(f, "%s := %v\n", k, vars[k].subst)
+	}
+	return f.Close()
+}
+
+// export writes the known substitutions to a file as CSV rows of
+// (name, expression), sorted by name. Unlike save, the output is
+// meant for spreadsheets rather than for load.
+func export(path string, vars map[string]*Vars) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	var ts []string
+	for k := range vars {
+		ts = append(ts, k)
+	}
+	sort.Strings(ts)
+	w := csv.NewWriter(f)
+	for _, k := range ts {
+		if err := w.Write([]string{k, vars[k].subst.String()}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// load learns all of the substitutions in a file written by save. If
+// any line of the file cannot be learned, none of them are.
+func load(path string, vars map[string]*Vars) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	learned := make(map[string]*Vars)
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		toks := split(s.Text())
+		if len(toks) == 0 || strings.HasPrefix(toks[0], "#") {
+			continue
+		}
+		i := 1
+		for i < len(toks) && toks[i] != ":=" {
+			i++
+		}
+		if i >= len(toks)-1 {
+			return fmt.Errorf("%s:%d: not a substitution: %q", path, n, s.Text())
+		}
+		lhs, err := build(toks[:i])
+		if err != nil || len(lhs) != 1 {
+			return fmt.Errorf("%s:%d: invalid expression %q: %v", path, n, toks[:i], err)
+		}
+		rhs, err := build(toks[i+1:])
+		if err != nil || len(rhs) != 1 {
+			return fmt.Errorf("%s:%d: invalid expression %q: %v", path, n, toks[i+1:], err)
+		}
+		if err := learn(learned, ":=", lhs[0], rhs[0]); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, n, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	for k, v := range learned {
+		vars[k] = v
+	}
+	return nil
+}
+
 func main() {
 	flag.Parse()
 
 	vars := make(map[string]*Vars)
+	var undos []map[string]*Vars
+	var history []string
 
 	t := lined.NewReader()
 	var f *os.File
@@ -196,6 +368,12 @@ func main() {
 		if len(toks) == 0 {
 			continue
 		}
+		if !strings.HasPrefix(toks[0], "#") {
+			history = append(history, strings.TrimSpace(line))
+			if len(history) > histLen {
+				history = history[1:]
+			}
+		}
 
 		if len(toks) == 1 {
 			switch toks[0] {
@@ -215,6 +393,19 @@ func main() {
 			case "help":
 				helpInfo()
 				continue
+			case "undo":
+				if len(undos) == 0 {
+					fmt.Println("nothing to undo")
+					continue
+				}
+				vars = undos[len(undos)-1]
+				undos = undos[:len(undos)-1]
+				continue
+			case "history":
+				for i, h := range history {
+					fmt.Printf(" %d: %s\n", i+1, h)
+				}
+				continue
 			default:
 				if strings.HasPrefix(toks[0], "#") {
 					// ignore comment
@@ -223,7 +414,7 @@ func main() {
 			}
 			// fall through - this is an expression.
 		} else if toks[0] == "file" {
-			path := strings.TrimSpace(line[5 : len(line)-1])
+			path := strings.TrimSpace(strings.TrimSpace(line)[len("file"):])
 			f, err = os.Open(path)
 			if err != nil {
 				fmt.Printf("unable to open %q: %v\n", path, err)
@@ -233,10 +424,48 @@ func main() {
 			fs = append(fs, f)
 			files = append(files, reading)
 			continue
+		} else if toks[0] == "save" || toks[0] == "load" || toks[0] == "export" {
+			path := strings.TrimSpace(strings.TrimSpace(line)[len(toks[0]):])
+			switch toks[0] {
+			case "save":
+				err = save(path, vars)
+			case "export":
+				err = export(path, vars)
+			default:
+				undos = remember(undos, vars)
+				if err = load(path, vars); err != nil {
+					undos = undos[:len(undos)-1]
+				}
+			}
+			if err != nil {
+				fmt.Printf("unable to %s %q: %v\n", toks[0], path, err)
+			}
+			continue
+		} else if toks[0] == "diff" {
+			if len(toks) < 3 || !factor.ValidSymbol(toks[1]) {
+				fmt.Printf("usage: diff <var> <exp>, not %q\n", toks)
+				continue
+			}
+			es, err := build(toks[2:])
+			if err != nil {
+				fmt.Printf("expression problem: %v\n", err)
+				pointAt(err)
+				continue
+			}
+			for _, e := range es {
+				d, err := derivative(inline(e, vars), factor.S(toks[1]))
+				if err != nil {
+					fmt.Printf("derivative problem: %v\n", err)
+					continue
+				}
+				fmt.Printf(" %v\n", d)
+			}
+			continue
 		} else if toks[0] == "reduce" {
 			es, err := build(toks[1:])
 			if err != nil {
 				fmt.Printf("expression problem: %v\n", err)
+				pointAt(err)
 				continue
 			}
 			for _, e := range es {
@@ -278,6 +507,7 @@ func main() {
 						continue
 					}
 					sym := factor.Prod(sub...)
+					undos = remember(undos, vars)
 					delete(vars, sym)
 					continue
 				}
@@ -288,38 +518,11 @@ func main() {
 				}
 				switch op {
 				case ":=", "=":
-					left, right, err := terms.Rearrange(lhs[0], rhs[0])
-					if err != nil {
-						fmt.Printf("assignment problem: %v\n", err)
-						continue
-					}
-					sub, ok := left.AsSubValue()
-					if !ok {
-						fmt.Printf("left-hand-side %q is not substitutable\n", left)
-						continue
-					}
-					if op == "=" {
-						right = inline(right, vars)
-					} else {
-						right.Reduce()
-					}
-					v := &Vars{
-						fact:  sub,
-						subst: right,
-					}
-					if left.Fns != nil {
-						var sym string
-						for k := range left.Fns {
-							if sym != "" {
-								fmt.Print("multiple function references in assignment: %v -> %v", left, right)
-								continue
-							}
-							sym = k
-						}
-						fn := left.Fns[sym]
-						v.fn = &fn
+					undos = remember(undos, vars)
+					if err := learn(vars, op, lhs[0], rhs[0]); err != nil {
+						undos = undos[:len(undos)-1]
+						fmt.Println(err)
 					}
-					vars[left.String()] = v
 					continue
 				case "mod":
 					if lhs[0].Den.String() != "1" {
@@ -344,6 +547,7 @@ func main() {
 		es, err := build(toks)
 		if err != nil {
 			fmt.Printf("syntax error %q: %v\n", toks, err)
+			pointAt(err)
 			continue
 		}
 		for _, e := range es {
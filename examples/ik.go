@@ -155,22 +155,11 @@ func splitUp(e *terms.Exp) cleaner {
 		}
 	}
 
-	// factor out any negative sign.
-	if div.String()[0] == '-' {
-		div = terms.Mul(div, minusOne)
-		rhs = terms.Mul(rhs, minusOne)
-	}
-
-	// Does div have any common multiples with rhs? If so, we
-	// factor them out, and let them cancel. Note, when doing
-	// this we even factor out negative powers - this helps keep
-	// factors in the numerator.
-	c := terms.Common(div, rhs)
-	if c.Fact != nil {
-		r := terms.NewExp(factor.Inv(c.Fact))
-		rhs = terms.Mul(r, rhs)
-		div = terms.Mul(r, div)
-	}
+	// Factor out any negative sign, and any common multiples of
+	// div and rhs, and let them cancel. Note, when doing this we
+	// even factor out negative powers - this helps keep factors
+	// in the numerator.
+	div, rhs, _ = terms.CancelCommon(div, rhs)
 
 	return cleaner{
 		b:   maxFS,
@@ -181,9 +170,8 @@ func splitUp(e *terms.Exp) cleaner {
 
 // Display a simple refactoring of the cleaner.
 func showCleaner(prefix string, r cleaner) {
-	if c := terms.Common(r.e); c.Fact != nil {
-		n := terms.NewExp(factor.Inv(c.Fact))
-		fmt.Print(prefix, r.b, " = (", terms.NewExp(c.Fact), ") * (", terms.Mul(n, r.e))
+	if c, rest := r.e.Factor(); c.Fact != nil {
+		fmt.Print(prefix, r.b, " = (", c.Exp(), ") * (", rest)
 	} else {
 		fmt.Print(prefix, r.b, " = (", r.e)
 	}
@@ -9,14 +9,21 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
+// ImaginaryUnit is the symbol reserved for the square root of -1.
+const ImaginaryUnit = "i"
+
 // Value captures a single factor. It is either a number or a symbol.
 type Value struct {
 	num *big.Rat
 
-	pow int
-	sym string
+	// The power of sym is pow/root. A root of 0 is equivalent to
+	// 1, and any other root is a value greater than 1 that is
+	// coprime with pow.
+	pow, root int
+	sym       string
 }
 
 // IsNum indicates that v is a rational number.
@@ -35,6 +42,9 @@ func (v Value) String() string {
 		return v.num.RatString()
 	}
 	if v.sym != "" {
+		if v.root > 1 {
+			return fmt.Sprintf("%s^(%d/%d)", v.sym, v.pow, v.root)
+		}
 		if v.pow == 1 {
 			return v.sym
 		}
@@ -49,6 +59,22 @@ func (v Value) Symbol() string {
 	return v.sym
 }
 
+// Pow returns the power of the symbol associated with this value. It
+// is 0 for numbers. For a fractional power, this is its numerator
+// (see Root).
+func (v Value) Pow() int {
+	return v.pow
+}
+
+// Root returns the denominator of the power of the symbol associated
+// with this value. It is 1 for integer powers.
+func (v Value) Root() int {
+	if v.root > 1 {
+		return v.root
+	}
+	return 1
+}
+
 // zero is a constant zero for comparisons.
 var zero = big.NewRat(0, 1)
 
@@ -88,6 +114,35 @@ func Sp(sym string, pow int) Value {
 	return Value{sym: sym, pow: pow}
 }
 
+// Sr converts a string and a rational power, num/den, to a symbol
+// value.
+func Sr(sym string, num, den int) Value {
+	if den < 0 {
+		num, den = -num, -den
+	}
+	g := new(big.Int).GCD(nil, nil, big.NewInt(int64(num)), big.NewInt(int64(den)))
+	if n := int(g.Int64()); n > 1 {
+		num, den = num/n, den/n
+	}
+	if den == 1 {
+		return Sp(sym, num)
+	}
+	return Value{sym: sym, pow: num, root: den}
+}
+
+// addPow returns the product of two values of the same symbol.
+func addPow(a, b Value) Value {
+	ar, br := a.Root(), b.Root()
+	return Sr(a.sym, a.pow*br+b.pow*ar, ar*br)
+}
+
+// CmpPow compares the powers of two values. It returns a negative
+// number if a has the lower power, a positive number if b does, and
+// 0 if they are the same.
+func CmpPow(a, b Value) int {
+	return a.pow*b.Root() - b.pow*a.Root()
+}
+
 type ByAlpha []Value
 
 func (a ByAlpha) Len() int      { return len(a) }
@@ -100,11 +155,12 @@ func (a ByAlpha) Less(i, j int) bool {
 		return false
 	}
 	// Higher powers first (after simplify this is moot).
-	return a[i].pow > a[j].pow
+	return CmpPow(a[i], a[j]) > 0
 }
 
 // Simplify condenses an unsorted array (product) of values into a
-// simplified (ordered) form.
+// simplified (ordered) form. Integer powers of the ImaginaryUnit are
+// reduced with i^2 = -1.
 func Simplify(vs ...Value) []Value {
 	if len(vs) == 0 {
 		return nil
@@ -132,19 +188,114 @@ func Simplify(vs ...Value) []Value {
 			res = append(res, s)
 			continue
 		}
-		last.pow += s.pow
+		last = addPow(last, s)
 		if last.pow == 0 {
 			res = res[:i]
 			continue
 		}
 		res = append(res[:i], last)
 	}
+	for i, v := range res {
+		if v.sym != ImaginaryUnit || v.Root() != 1 {
+			continue
+		}
+		p := (v.pow%4 + 4) % 4
+		if p >= 2 {
+			res[0].num.Neg(res[0].num)
+		}
+		if p%2 == 1 {
+			res[i] = S(ImaginaryUnit)
+		} else {
+			res = append(res[:i], res[i+1:]...)
+		}
+		break
+	}
 	return res
 }
 
-// Prod returns a string representing a product of values. This
-// function does not attempt to simplify the array first.
-func Prod(vs ...Value) string {
+// maxCached limits the number of results held by SimplifyCached.
+const maxCached = 4096
+
+var (
+	cacheMu sync.Mutex
+	cache   = make(map[string][]Value)
+)
+
+// copyValues returns a copy of vs that shares no storage with it.
+func copyValues(vs []Value) []Value {
+	if vs == nil {
+		return nil
+	}
+	c := make([]Value, len(vs))
+	for i, v := range vs {
+		if v.num != nil {
+			v = R(v.num)
+		}
+		c[i] = v
+	}
+	return c
+}
+
+// SimplifyCached returns the same result as Simplify, but remembers
+// recent results so repeatedly simplifying the same list of factors
+// is cheaper. It is safe for concurrent use. The returned slice can
+// be modified without affecting the cache.
+func SimplifyCached(vs ...Value) []Value {
+	var b strings.Builder
+	for _, v := range vs {
+		b.WriteString(v.String())
+		b.WriteByte('*')
+	}
+	key := b.String()
+	cacheMu.Lock()
+	res, ok := cache[key]
+	cacheMu.Unlock()
+	if ok {
+		return copyValues(res)
+	}
+	res = Simplify(vs...)
+	cacheMu.Lock()
+	if len(cache) >= maxCached {
+		cache = make(map[string][]Value)
+	}
+	cache[key] = copyValues(res)
+	cacheMu.Unlock()
+	return res
+}
+
+// FormatOptions control how Values are rendered as text. The zero
+// value renders the same as String and Prod.
+type FormatOptions struct {
+	// Sep separates the factors of a product. An empty Sep means
+	// "*". Use " " or "·" for output intended for documents.
+	Sep string
+
+	// Superscript renders integer powers with Unicode superscript
+	// digits, x² in place of x^2. Fractional powers are still
+	// rendered as x^(1/2) since Unicode has no superscript slash.
+	Superscript bool
+}
+
+// superscripts holds the Unicode superscript forms of the characters
+// used to render an integer power.
+var superscripts = strings.NewReplacer(
+	"-", "⁻",
+	"0", "⁰", "1", "¹", "2", "²", "3", "³", "4", "⁴",
+	"5", "⁵", "6", "⁶", "7", "⁷", "8", "⁸", "9", "⁹",
+)
+
+// Value renders v according to the options of o.
+func (o FormatOptions) Value(v Value) string {
+	if !o.Superscript || v.num != nil || v.sym == "" || v.root > 1 || v.pow == 1 {
+		return v.String()
+	}
+	return v.sym + superscripts.Replace(strconv.Itoa(v.pow))
+}
+
+// Prod returns a string representing a product of values rendered
+// according to the options of o. Like Prod, it does not attempt to
+// simplify the array first.
+func (o FormatOptions) Prod(vs ...Value) string {
 	if len(vs) == 0 {
 		return "0"
 	}
@@ -160,9 +311,19 @@ func Prod(vs ...Value) string {
 				continue
 			}
 		}
-		x = append(x, v.String())
+		x = append(x, o.Value(v))
+	}
+	sep := o.Sep
+	if sep == "" {
+		sep = "*"
 	}
-	return prefix + strings.Join(x, "*")
+	return prefix + strings.Join(x, sep)
+}
+
+// Prod returns a string representing a product of values. This
+// function does not attempt to simplify the array first.
+func Prod(vs ...Value) string {
+	return FormatOptions{}.Prod(vs...)
 }
 
 // Segment simplifies a set of factors and returns the numerical
@@ -176,13 +337,21 @@ func Segment(vs ...Value) (*big.Rat, []Value, string) {
 	return x[0].num, x[1:], Prod(x[1:]...)
 }
 
-// Order returns the power complexity of the Value slice, a.
+// Order returns the power complexity of the Value slice, a. A
+// fractional total power is rounded away from zero.
 func Order(a []Value) int {
-	n := 0
+	n, d := 0, 1
 	for _, v := range a {
-		n += v.pow
+		r := v.Root()
+		n, d = n*r+v.pow*d, d*r
+	}
+	if n%d == 0 {
+		return n / d
+	}
+	if n < 0 {
+		return n/d - 1
 	}
-	return n
+	return n/d + 1
 }
 
 // Replace replaces copies of b found in a with c. The number of times b
@@ -211,12 +380,12 @@ func Replace(a, b, c []Value, max int) (int, []Value) {
 					// the sign of the power is the same.
 					break GIVEUP
 				}
-				np := u.pow - t.pow
-				if np*t.pow < 0 {
+				np := addPow(u, Sr(t.sym, -t.pow, t.Root()))
+				if np.pow*t.pow < 0 {
 					break GIVEUP
 				}
-				if np != 0 {
-					nf = append(nf, Sp(t.sym, np))
+				if np.pow != 0 {
+					nf = append(nf, np)
 				}
 				i++
 				break
@@ -225,8 +394,11 @@ func Replace(a, b, c []Value, max int) (int, []Value) {
 		if i != len(pf) {
 			break
 		}
-		// Whole match found.
-		qf = Simplify(append(append(nf, qf[j:]...), append(c, R(r))...)...)
+		// Whole match found. Note, c is not appended to since
+		// its storage may be shared with the caller.
+		nf = append(nf, qf[j:]...)
+		nf = append(nf, c...)
+		qf = Simplify(append(nf, R(r))...)
 		n++
 	}
 	return n, qf
@@ -244,7 +416,7 @@ func GCF(a, b []Value) []Value {
 		} else {
 			i++
 			j++
-			if x.pow < y.pow {
+			if CmpPow(x, y) < 0 {
 				g = append(g, x)
 			} else {
 				g = append(g, y)
@@ -263,8 +435,9 @@ func Inv(a []Value) []Value {
 			continue
 		}
 		r = append(r, Value{
-			pow: -x.pow,
-			sym: x.sym,
+			pow:  -x.pow,
+			root: x.root,
+			sym:  x.sym,
 		})
 	}
 	return r
@@ -279,8 +452,9 @@ func Den(vs []Value) []Value {
 			continue
 		}
 		r = append(r, Value{
-			pow: -x.pow,
-			sym: x.sym,
+			pow:  -x.pow,
+			root: x.root,
+			sym:  x.sym,
 		})
 	}
 	return r
@@ -308,16 +482,52 @@ func skipSpace(s string) int {
 	return 0
 }
 
-var isValidLabel = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9]*$`).MatchString
+var isValidLabel = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9_]*$`).MatchString
 
 // ValidSymbol confirms that a symbol can be considered externally
-// meaningful. Various packages may use other forms for book keeping
-// purposes (factoring etc), but for "external" purposes this is the
-// only valid form.
+// meaningful: a letter followed by any number of letters, digits and
+// underscores, such as c_1 or B12. Various packages use symbols with
+// a leading underscore for book keeping purposes (factoring,
+// function tokens like _FN0FN_, etc), so those are never valid for
+// "external" purposes.
 func ValidSymbol(token string) bool {
 	return isValidLabel(token)
 }
 
+// symbolInfo holds the annotation of a registered symbol.
+type symbolInfo struct {
+	description, unit string
+}
+
+var (
+	infoMu  sync.Mutex
+	symInfo = make(map[string]symbolInfo)
+)
+
+// RegisterSymbol annotates the symbol, name, with a description and
+// a unit. For example, RegisterSymbol("d0", "link 0 length",
+// "meters"). Registering a symbol again replaces its annotation. The
+// annotations are only for display purposes: no algebraic operation
+// depends on them.
+func RegisterSymbol(name, description, unit string) {
+	infoMu.Lock()
+	defer infoMu.Unlock()
+	symInfo[name] = symbolInfo{description: description, unit: unit}
+}
+
+// Info returns the description and unit registered for the symbol of
+// v with RegisterSymbol. The ok value is false for numbers and for
+// unregistered symbols.
+func (v Value) Info() (description, unit string, ok bool) {
+	if v.num != nil {
+		return
+	}
+	infoMu.Lock()
+	defer infoMu.Unlock()
+	si, ok := symInfo[v.sym]
+	return si.description, si.unit, ok
+}
+
 // subParse parses the next token of interest or returns ErrDone.
 // Leading spaces are ignored. When a leading plus or minus sign is
 // considered part of the factors, the signOK value is true. Otherwise
@@ -327,6 +537,10 @@ func subParse(signOK bool, s string) (string, int, error) {
 	if base == len(s) {
 		return "", 0, ErrDone
 	}
+	if strings.HasPrefix(s[base:], "**") {
+		// Python style power operator.
+		return "^", base + 2, nil
+	}
 	if strings.Contains("^*/", s[base:base+1]) {
 		return s[base : base+1], base + 1, nil
 	}
@@ -336,7 +550,12 @@ func subParse(signOK bool, s string) (string, int, error) {
 			return "", base, ErrDone
 		}
 		sign = c
-		base += 1 + skipSpace(s[base+1:])
+		base++
+		base += skipSpace(s[base:])
+		if base == len(s) {
+			// A sign must be followed by something.
+			return "", base, ErrSyntax
+		}
 	}
 	if strings.Contains(allDigits, s[base:base+1]) {
 		for i := 1 + base; i < len(s); i++ {
@@ -365,6 +584,69 @@ func subParse(signOK bool, s string) (string, int, error) {
 	return s[base:], len(s), nil
 }
 
+// ratPower matches a parenthesized rational power, "(-1/2)".
+var ratPower = regexp.MustCompile(`^\s*\(\s*([+-]?\d+)\s*(/\s*(\d+)\s*)?\)`)
+
+// numPow raises the rational number z to the integer power n.
+func numPow(z *big.Rat, n int) *big.Rat {
+	neg := n < 0
+	if neg {
+		n = -n
+	}
+	en := big.NewInt(int64(n))
+	num := new(big.Rat).SetInt(new(big.Int).Exp(z.Num(), en, nil))
+	den := new(big.Rat).SetInt(new(big.Int).Exp(z.Denom(), en, nil))
+	q := new(big.Rat)
+	if neg {
+		q.Inv(num)
+		q.Mul(q, den)
+	} else {
+		q.Inv(den)
+		q.Mul(q, num)
+	}
+	return q
+}
+
+// intRoot returns the integer n-th root of the non-negative x,
+// rounded down, and whether it is exact.
+func intRoot(x *big.Int, n int) (*big.Int, bool) {
+	lo := big.NewInt(0)
+	hi := new(big.Int).Lsh(big.NewInt(1), uint(x.BitLen()/n+1))
+	one := big.NewInt(1)
+	for lo.Cmp(hi) < 0 {
+		mid := new(big.Int).Add(lo, hi)
+		mid.Add(mid, one).Rsh(mid, 1)
+		if new(big.Int).Exp(mid, big.NewInt(int64(n)), nil).Cmp(x) > 0 {
+			hi.Sub(mid, one)
+		} else {
+			lo = mid
+		}
+	}
+	return lo, new(big.Int).Exp(lo, big.NewInt(int64(n)), nil).Cmp(x) == 0
+}
+
+// RatRoot returns the exact rational n-th root of r, if it has
+// one. Odd roots of negative numbers are negative.
+func RatRoot(r *big.Rat, n int) (*big.Rat, bool) {
+	neg := r.Sign() < 0
+	if neg && n%2 == 0 {
+		return nil, false
+	}
+	a, ok := intRoot(new(big.Int).Abs(r.Num()), n)
+	if !ok {
+		return nil, false
+	}
+	b, ok := intRoot(r.Denom(), n)
+	if !ok {
+		return nil, false
+	}
+	q := new(big.Rat).SetFrac(a, b)
+	if neg {
+		q.Neg(q)
+	}
+	return q, true
+}
+
 const (
 	parseNone = iota
 	parseMul
@@ -377,12 +659,41 @@ const (
 //
 //	-33*y*x  -> -33*x*y
 //	+33*x^4*y^-3*z/x/3 -> 11*x^3*y^-3*z
+//	x^(1/2)*x^(3/2) -> x^2
+//
+// The power operator, ^, can also be written as **. Fractional
+// powers must be enclosed in parentheses. A fractional power of a
+// number is evaluated exactly, 8^(1/3) -> 2, and is only a syntax
+// error when the result is irrational. When ErrSyntax is returned,
+// the returned offset is that of the token where parsing failed.
 func Parse(s string) ([]Value, int, error) {
 	modifier := parseMul
 	signOK := true
 	var vs []Value
 	var i int
 	for i < len(s) {
+		if m := ratPower.FindStringSubmatch(s[i:]); modifier == parsePow && m != nil {
+			p, _ := strconv.Atoi(m[1])
+			q := 1
+			if m[3] != "" {
+				q, _ = strconv.Atoi(m[3])
+			}
+			if q == 0 {
+				return nil, i + skipSpace(s[i:]), ErrSyntax
+			}
+			last := vs[len(vs)-1]
+			if last.num == nil {
+				vs[len(vs)-1] = Sr(last.sym, last.pow*p, last.Root()*q)
+			} else if r, ok := RatRoot(numPow(last.num, p), q); ok {
+				vs[len(vs)-1].num = r
+			} else {
+				return nil, i + skipSpace(s[i:]), ErrSyntax
+			}
+			modifier = parseNone
+			signOK = false
+			i += len(m[0])
+			continue
+		}
 		tok, d, err := subParse(signOK, s[i:])
 		// fmt.Printf("[%s] %v %d %q %d: %v\n", s[i:], signOK, modifier, tok, d, err)
 		if err != nil {
@@ -391,7 +702,7 @@ func Parse(s string) ([]Value, int, error) {
 		if strings.Contains(allLetters, strings.ToLower(tok[:1])) {
 			switch modifier {
 			case parsePow, parseNone:
-				return nil, 0, ErrSyntax
+				return nil, i + skipSpace(s[i:]), ErrSyntax
 			case parseMul:
 				vs = append(vs, S(tok))
 			case parseDiv:
@@ -414,43 +725,26 @@ func Parse(s string) ([]Value, int, error) {
 			case parsePow:
 				n, err := strconv.Atoi(tok)
 				if err != nil {
-					return nil, 0, ErrSyntax
+					return nil, i + skipSpace(s[i:]), ErrSyntax
 				}
-				z := vs[len(vs)-1].num
-				if z == nil {
-					vs[len(vs)-1].pow *= n
+				last := vs[len(vs)-1]
+				if last.num == nil {
+					vs[len(vs)-1] = Sr(last.sym, last.pow*n, last.Root())
 					break
 				}
-				neg := n < 0
-				if neg {
-					n = -n
-				}
-				en := big.NewInt(int64(n))
-				num := new(big.Rat).SetInt(new(big.Int).Exp(z.Num(), en, nil))
-				den := new(big.Rat).SetInt(new(big.Int).Exp(z.Denom(), en, nil))
-				q := new(big.Rat)
-				if neg {
-					q.Inv(num)
-					q.Mul(q, den)
-				} else {
-					q.Inv(den)
-					q.Mul(q, num)
-				}
-				vs[len(vs)-1].num = q
+				vs[len(vs)-1].num = numPow(last.num, n)
 			case parseNone:
-				return nil, 0, ErrSyntax
-			case parseMul:
+				return nil, i + skipSpace(s[i:]), ErrSyntax
+			case parseMul, parseDiv:
 				num, ok := new(big.Rat).SetString(tok)
 				if !ok {
-					return nil, 0, ErrSyntax
+					return nil, i + skipSpace(s[i:]), ErrSyntax
 				}
-				vs = append(vs, Value{
-					num: num,
-				})
-			case parseDiv:
-				num, ok := new(big.Rat).SetString("1/" + tok)
-				if !ok {
-					return nil, 0, ErrSyntax
+				if modifier == parseDiv {
+					if num.Sign() == 0 {
+						return nil, i + skipSpace(s[i:]), ErrSyntax
+					}
+					num.Inv(num)
 				}
 				vs = append(vs, Value{
 					num: num,
@@ -460,7 +754,7 @@ func Parse(s string) ([]Value, int, error) {
 			signOK = false
 		} else {
 			if modifier != parseNone {
-				return nil, 0, ErrSyntax
+				return nil, i + skipSpace(s[i:]), ErrSyntax
 			}
 			signOK = true
 			switch tok[0] {
@@ -477,7 +771,7 @@ func Parse(s string) ([]Value, int, error) {
 		i += d
 	}
 	if modifier != parseNone {
-		return nil, 0, ErrSyntax
+		return nil, i + skipSpace(s[i:]), ErrSyntax
 	}
 	return Simplify(vs...), i, nil
 }
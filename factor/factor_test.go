@@ -1,6 +1,7 @@
 package factor
 
 import (
+	"fmt"
 	"strings"
 	"testing"
 )
@@ -24,6 +25,26 @@ func TestString(t *testing.T) {
 	}
 }
 
+func TestFormatOptions(t *testing.T) {
+	vs := []Value{D(-3, 2), S("a"), Sp("b", 2), Sp("c", -12), Sr("d", 1, 2)}
+	tests := []struct {
+		o    FormatOptions
+		want string
+	}{
+		{want: "-3/2*a*b^2*c^-12*d^(1/2)"},
+		{o: FormatOptions{Sep: " "}, want: "-3/2 a b^2 c^-12 d^(1/2)"},
+		{o: FormatOptions{Sep: "·", Superscript: true}, want: "-3/2·a·b²·c⁻¹²·d^(1/2)"},
+	}
+	for i, x := range tests {
+		if got := x.o.Prod(vs...); got != x.want {
+			t.Errorf("[%d] got=%q want=%q", i, got, x.want)
+		}
+	}
+	if got, want := (FormatOptions{Sep: " "}).Prod(D(-1, 1), S("x")), "-x"; got != want {
+		t.Errorf("got=%q want=%q", got, want)
+	}
+}
+
 func TestSimplify(t *testing.T) {
 	vs := []struct {
 		v []Value
@@ -76,6 +97,44 @@ func TestSimplify(t *testing.T) {
 	}
 }
 
+func TestSimplifyCached(t *testing.T) {
+	vs := [][]Value{
+		{},
+		{S("x"), S("y"), D(1, 3), S("a")},
+		{D(3, 1), D(-1, 6), S("a"), D(2, 1), Sp("a", 2)},
+		{D(3, 1), S("a"), S("a"), D(1, 3), Sp("a", -2)},
+		{S("i"), Sp("i", 2), Sr("x", 1, 2)},
+		{D(0, 1), S("x")},
+	}
+	for n := 0; n < 2; n++ {
+		for i, v := range vs {
+			want := Simplify(v...)
+			got := SimplifyCached(v...)
+			if g, w := fmt.Sprint(got), fmt.Sprint(want); g != w {
+				t.Errorf("[%d,%d] got=%q want=%q", n, i, g, w)
+			}
+			if len(got) != 0 {
+				// Modifying the result must not affect the cache.
+				got[0].num.SetInt64(7)
+			}
+		}
+	}
+}
+
+func BenchmarkSimplify(b *testing.B) {
+	v := []Value{D(3, 1), S("b"), Sp("a", 2), D(-1, 6), S("a"), S("c"), Sp("b", -3), D(2, 1), Sp("a", 2)}
+	for i := 0; i < b.N; i++ {
+		Simplify(v...)
+	}
+}
+
+func BenchmarkSimplifyCached(b *testing.B) {
+	v := []Value{D(3, 1), S("b"), Sp("a", 2), D(-1, 6), S("a"), S("c"), Sp("b", -3), D(2, 1), Sp("a", 2)}
+	for i := 0; i < b.N; i++ {
+		SimplifyCached(v...)
+	}
+}
+
 func TestReplace(t *testing.T) {
 	vs := []struct {
 		a, b, c []Value
@@ -117,6 +176,20 @@ func TestReplace(t *testing.T) {
 			n: 1,
 			s: "a^-1*b^-1",
 		},
+		{
+			a: []Value{Sr("a", 3, 2)},
+			b: []Value{Sr("a", 1, 2)},
+			c: []Value{S("b")},
+			n: 3,
+			s: "b^3",
+		},
+		{
+			a: []Value{Sp("a", 2)},
+			b: []Value{Sr("a", 3, 2)},
+			c: []Value{S("b")},
+			n: 1,
+			s: "a^(1/2)*b",
+		},
 	}
 	for i, v := range vs {
 		if n, x := Replace(v.a, v.b, v.c, 0); n != v.n {
@@ -127,6 +200,21 @@ func TestReplace(t *testing.T) {
 	}
 }
 
+func TestReplaceAliasing(t *testing.T) {
+	backing := []Value{S("b"), S("keep")}
+	c := backing[:1]
+	for i := 0; i < 2; i++ {
+		if n, x := Replace([]Value{Sp("a", 2)}, []Value{S("a")}, c, 0); n != 2 {
+			t.Errorf("[%d] expected 2 replacements: got %d", i, n)
+		} else if s := Prod(x...); s != "b^2" {
+			t.Errorf("[%d] got=%q want=%q", i, s, "b^2")
+		}
+	}
+	if s := Prod(backing...); s != "b*keep" {
+		t.Errorf("replacement modified: got=%q want=%q", s, "b*keep")
+	}
+}
+
 func TestParse(t *testing.T) {
 	vs := []struct {
 		before, after, trimmed string
@@ -144,6 +232,28 @@ func TestParse(t *testing.T) {
 		{"a^-2*2^-3", "1/8*a^-2", "1/8*a^-2"},
 		{"a/b^2", "a*b^-2", "a*b^-2"},
 		{"a/-b^2", "-1*a*b^-2", "-a*b^-2"},
+		{"x**3", "x^3", "x^3"},
+		{"x ^ 3", "x^3", "x^3"},
+		{"2 ** 10", "1024", "1024"},
+		{"a**-2 * b", "a^-2*b", "a^-2*b"},
+		{"x^(1/2)", "x^(1/2)", "x^(1/2)"},
+		{"x^(1/2)*x^(1/2)", "x", "x"},
+		{"x^(2/4)*y^( -1 / 3)", "x^(1/2)*y^(-1/3)", "x^(1/2)*y^(-1/3)"},
+		{"x^(1/2)^2", "x", "x"},
+		{"x**(3/2)/x", "x^(1/2)", "x^(1/2)"},
+		{"2^(3)", "8", "8"},
+		{"i^2", "-1", "-1"},
+		{"i^3*x", "-1*i*x", "-i*x"},
+		{"i*i^3", "1", "1"},
+		{"i^-1", "-1*i", "-i"},
+		{"+a", "a", "a"},
+		{"-+-a", "a", "a"},
+		{"a/-2", "-1/2*a", "-1/2*a"},
+		{"4^(1/2)", "2", "2"},
+		{"8^(1/3)*x", "2*x", "2*x"},
+		{"8^(2/3)", "4", "4"},
+		{"4^(-1/2)", "1/2", "1/2"},
+		{"-27^(1/3)", "-3", "-3"},
 	}
 	for i, v := range vs {
 		x, j, err := Parse(v.before)
@@ -173,6 +283,63 @@ func TestParse(t *testing.T) {
 			t.Errorf("[%d] test %q -> %v got=%q (want %q)", i, v.before, x, text, v.trimmed)
 		}
 	}
+	for i, s := range []string{"x^^2", "x**", "x***2", "x^", "x^(1/0)", "2^(1/2)", "+", "-", "a/0"} {
+		if x, _, err := Parse(s); err != ErrSyntax {
+			t.Errorf("[%d] parsing %q: got=%v, %v want=%v", i, s, x, err, ErrSyntax)
+		}
+	}
+}
+
+func TestValidSymbol(t *testing.T) {
+	vs := []struct {
+		s  string
+		ok bool
+	}{
+		{"x", true},
+		{"c_1", true},
+		{"B12", true},
+		{"a_b_", true},
+		{"_x", false},
+		{"_FN0FN_", false},
+		{"1a", false},
+		{"", false},
+		{"a-b", false},
+		{"a b", false},
+	}
+	for i, v := range vs {
+		if ok := ValidSymbol(v.s); ok != v.ok {
+			t.Errorf("[%d] ValidSymbol(%q): got=%v want=%v", i, v.s, ok, v.ok)
+		}
+	}
+	if x, _, err := Parse("c_1^2*B12"); err != nil || Prod(x...) != "B12*c_1^2" {
+		t.Errorf("parsing underscored symbols: got=%v, %v", x, err)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	RegisterSymbol("d0", "link 0 length", "meters")
+	RegisterSymbol("th", "joint angle", "radians")
+	RegisterSymbol("th", "joint 1 angle", "radians")
+	vs := []struct {
+		v          Value
+		desc, unit string
+		ok         bool
+	}{
+		{v: S("d0"), desc: "link 0 length", unit: "meters", ok: true},
+		{v: Sp("d0", -2), desc: "link 0 length", unit: "meters", ok: true},
+		{v: S("th"), desc: "joint 1 angle", unit: "radians", ok: true},
+		{v: S("d1")},
+		{v: D(3, 1)},
+	}
+	for i, x := range vs {
+		desc, unit, ok := x.v.Info()
+		if desc != x.desc || unit != x.unit || ok != x.ok {
+			t.Errorf("[%d] %v: got=%q,%q,%v want=%q,%q,%v", i, x.v, desc, unit, ok, x.desc, x.unit, x.ok)
+		}
+	}
+	if got := Prod(Simplify(S("d0"), S("d0"), D(2, 1))...); got != "2*d0^2" {
+		t.Errorf("annotated symbol simplified to %q", got)
+	}
 }
 
 func TestGCF(t *testing.T) {
@@ -200,6 +367,11 @@ func TestGCF(t *testing.T) {
 			b: []Value{Sp("a", 4), Sp("c", 3)},
 			s: "a^3*c^2",
 		},
+		{
+			a: []Value{Sr("a", 1, 2), Sp("b", 2)},
+			b: []Value{Sp("a", 1), Sr("b", 5, 3)},
+			s: "a^(1/2)*b^(5/3)",
+		},
 	}
 	for i, v := range vs {
 		g := GCF(v.a, v.b)
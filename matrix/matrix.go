@@ -3,7 +3,10 @@ package matrix
 
 import (
 	"fmt"
+	"math/big"
+	"runtime"
 	"strings"
+	"sync"
 
 	"zappem.net/pub/math/algex/factor"
 	"zappem.net/pub/math/algex/terms"
@@ -14,6 +17,9 @@ type Matrix struct {
 	rows, cols int
 	// The matrix elements arranged, [r=0,c=0], [0,1], [0,2] ...
 	data []*terms.Exp
+	// Optional denominators for the matrix elements, arranged as
+	// data. A nil dens, or a nil entry, implies a denominator of 1.
+	dens []*terms.Exp
 }
 
 // NewMatrix creates a rows x cols matrix.
@@ -29,12 +35,21 @@ func NewMatrix(rows, cols int) (*Matrix, error) {
 	return m, nil
 }
 
+// Dims returns the number of rows and columns of a matrix.
+func (m *Matrix) Dims() (rows, cols int) {
+	return m.rows, m.cols
+}
+
 // String serializes a matrix for displaying.
 func (m *Matrix) String() string {
 	var rs []string
 	for r := 0; r < m.rows; r++ {
 		var cs []string
 		for c := 0; c < m.cols; c++ {
+			if m.den(r, c) != nil {
+				cs = append(cs, m.Frac(r, c).String())
+				continue
+			}
 			cs = append(cs, m.data[c+m.cols*r].String())
 		}
 		rs = append(rs, "["+strings.Join(cs, ", ")+"]")
@@ -48,14 +63,128 @@ func (m *Matrix) Set(row, col int, e *terms.Exp) error {
 		return fmt.Errorf("bad cell: [%d,%d] in %dx%d matrix", row, col, m.rows, m.cols)
 	}
 	m.data[col+m.cols*row] = e
+	if m.dens != nil {
+		m.dens[col+m.cols*row] = nil
+	}
 	return nil
 }
 
-// El returns the row,col element of the matrix.
+// El returns the row,col element of the matrix. El panics if the
+// element has a non-trivial denominator (see SetFrac), since no
+// single expression holds its value. Use Frac for such elements.
 func (m *Matrix) El(row, col int) *terms.Exp {
+	if m.den(row, col) != nil {
+		panic(fmt.Sprintf("fractional element [%d,%d] = %v", row, col, m.Frac(row, col)))
+	}
+	return m.el(row, col)
+}
+
+// el returns the numerator of the row,col element of the matrix.
+func (m *Matrix) el(row, col int) *terms.Exp {
 	return m.data[col+m.cols*row]
 }
 
+// den returns the denominator of the row,col element, or nil if it
+// is 1.
+func (m *Matrix) den(row, col int) *terms.Exp {
+	if m.dens == nil {
+		return nil
+	}
+	return m.dens[col+m.cols*row]
+}
+
+// fractional indicates that some element of m has a non-trivial
+// denominator.
+func (m *Matrix) fractional() bool {
+	for _, d := range m.dens {
+		if d != nil {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	one      = terms.NewExp([]factor.Value{factor.D(1, 1)})
+	minusOne = terms.NewExp([]factor.Value{factor.D(-1, 1)})
+)
+
+// Frac returns the row,col element of the matrix as a fraction.
+func (m *Matrix) Frac(row, col int) *terms.Frac {
+	num := m.el(row, col)
+	if num == nil {
+		num = terms.NewExp()
+	}
+	den := m.den(row, col)
+	if den == nil {
+		den = one
+	}
+	return &terms.Frac{Num: num, Den: den}
+}
+
+// SetFrac sets the value of a matrix element to a ratio of two
+// expressions. The ratio is reduced, and if the resulting
+// denominator is a single term, the element is stored as a simple
+// expression. Fractions containing functions are not supported.
+func (m *Matrix) SetFrac(row, col int, f *terms.Frac) error {
+	if row < 0 || col < 0 || row >= m.rows || col >= m.cols {
+		return fmt.Errorf("bad cell: [%d,%d] in %dx%d matrix", row, col, m.rows, m.cols)
+	}
+	if f.Fns != nil {
+		return fmt.Errorf("unsupported function in cell [%d,%d]: %v", row, col, f)
+	}
+	if f.Den.Equals(one) {
+		return m.Set(row, col, f.Num)
+	}
+	g := &terms.Frac{Num: f.Num, Den: f.Den}
+	g.Reduce()
+	if g.Num.IsZero() {
+		return m.Set(row, col, nil)
+	}
+	if e, ok := flatten(g); ok {
+		return m.Set(row, col, e)
+	}
+	if m.dens == nil {
+		m.dens = make([]*terms.Exp, len(m.data))
+	}
+	m.data[col+m.cols*row] = g.Num
+	m.dens[col+m.cols*row] = g.Den
+	return nil
+}
+
+// flatten attempts to express a fraction as a single expression. This
+// is only possible when the denominator is a single term.
+func flatten(f *terms.Frac) (*terms.Exp, bool) {
+	ts := f.Den.Terms()
+	if len(ts) != 1 {
+		return nil, false
+	}
+	for _, t := range ts {
+		inv := []factor.Value{factor.R(new(big.Rat).Inv(t.Coeff))}
+		return terms.Mul(f.Num, terms.NewExp(append(inv, factor.Inv(t.Fact)...))), true
+	}
+	return nil, false
+}
+
+// fracAdd returns the unreduced sum of two fractions.
+func fracAdd(a, b *terms.Frac) *terms.Frac {
+	if a.Den.Equals(b.Den) {
+		return &terms.Frac{Num: a.Num.Add(b.Num), Den: a.Den}
+	}
+	return &terms.Frac{
+		Num: terms.Sum(terms.Mul(a.Num, b.Den), terms.Mul(b.Num, a.Den)),
+		Den: terms.Mul(a.Den, b.Den),
+	}
+}
+
+// fracMul returns the unreduced product of two fractions.
+func fracMul(a, b *terms.Frac) *terms.Frac {
+	return &terms.Frac{
+		Num: terms.Mul(a.Num, b.Num),
+		Den: terms.Mul(a.Den, b.Den),
+	}
+}
+
 // Identity returns a square identity matrix of dimension n.
 func Identity(n int) (*Matrix, error) {
 	if n <= 0 {
@@ -76,14 +205,34 @@ func (m *Matrix) Transpose() *Matrix {
 	}
 	for i := 0; i < m.rows; i++ {
 		for j := 0; j < m.cols; j++ {
-			n.Set(j, i, m.El(i, j))
+			n.Set(j, i, m.el(i, j))
+		}
+	}
+	if m.dens != nil {
+		n.dens = make([]*terms.Exp, len(n.data))
+		for i := 0; i < m.rows; i++ {
+			for j := 0; j < m.cols; j++ {
+				n.dens[i+n.cols*j] = m.den(i, j)
+			}
 		}
 	}
 	return n
 }
 
+// parallelMin is the number of element products above which Mul
+// computes the cells of its result concurrently.
+const parallelMin = 256
+
 // Mul multiplies m x n with conventional matrix multiplication.
 func (m *Matrix) Mul(n *Matrix) (*Matrix, error) {
+	return m.mul(n, m.rows*m.cols*n.cols >= parallelMin)
+}
+
+// mul multiplies m x n. When parallel is true, the cells of the
+// result are computed by a pool of goroutines. Each cell is an
+// independent sum of products, so the result does not depend on the
+// order in which the cells are computed.
+func (m *Matrix) mul(n *Matrix, parallel bool) (*Matrix, error) {
 	if m.cols != n.rows {
 		return nil, fmt.Errorf("a cols(%d) != b rows(%d)", m.cols, n.rows)
 	}
@@ -91,18 +240,50 @@ func (m *Matrix) Mul(n *Matrix) (*Matrix, error) {
 	if err != nil {
 		return nil, err
 	}
-	for r := 0; r < a.rows; r++ {
-		for c := 0; c < a.cols; c++ {
-			var e []*terms.Exp
+	cell := func(r, c int) {
+		var e []*terms.Exp
+		for i := 0; i < m.cols; i++ {
+			x, y := m.El(r, i), n.El(i, c)
+			if x != nil && y != nil {
+				e = append(e, terms.Mul(x, y))
+			}
+		}
+		a.Set(r, c, terms.Sum(e...))
+	}
+	if m.fractional() || n.fractional() {
+		// SetFrac allocates dens on demand, so do it up front.
+		a.dens = make([]*terms.Exp, len(a.data))
+		cell = func(r, c int) {
+			f := terms.NewFrac()
+			f.Num = terms.NewExp()
 			for i := 0; i < m.cols; i++ {
-				x, y := m.El(r, i), n.El(i, c)
-				if x != nil && y != nil {
-					e = append(e, terms.Mul(x, y))
-				}
+				f = fracAdd(f, fracMul(m.Frac(r, i), n.Frac(i, c)))
 			}
-			a.Set(r, c, terms.Sum(e...))
+			a.SetFrac(r, c, f)
+		}
+	}
+	if !parallel {
+		for i := range a.data {
+			cell(i/a.cols, i%a.cols)
 		}
+		return a, nil
 	}
+	cells := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range cells {
+				cell(i/a.cols, i%a.cols)
+			}
+		}()
+	}
+	for i := range a.data {
+		cells <- i
+	}
+	close(cells)
+	wg.Wait()
 	return a, nil
 }
 
@@ -121,6 +302,15 @@ func (m *Matrix) Sum(n *Matrix, scale *terms.Exp) (*Matrix, error) {
 		return nil, fmt.Errorf("inequivalent dimensions %dx%d != %dx%d", m.rows, m.cols, n.rows, n.cols)
 	}
 	a, _ := NewMatrix(m.rows, m.cols)
+	if m.fractional() || n.fractional() {
+		s := &terms.Frac{Num: scale, Den: one}
+		for r := 0; r < m.rows; r++ {
+			for c := 0; c < m.cols; c++ {
+				a.SetFrac(r, c, fracAdd(m.Frac(r, c), fracMul(n.Frac(r, c), s)))
+			}
+		}
+		return a, nil
+	}
 	for r := 0; r < m.rows; r++ {
 		for c := 0; c < m.cols; c++ {
 			if q := n.El(r, c); q == nil {
@@ -144,13 +334,685 @@ func (m *Matrix) Add(n *Matrix, scale *terms.Exp) *Matrix {
 	return a
 }
 
+// Scale returns a copy of m with every element multiplied by the
+// expression e. Scaling by zero yields an all zero matrix.
+func (m *Matrix) Scale(e *terms.Exp) *Matrix {
+	n, _ := NewMatrix(m.rows, m.cols)
+	if e == nil || e.IsZero() {
+		return n
+	}
+	for r := 0; r < m.rows; r++ {
+		for c := 0; c < m.cols; c++ {
+			x := m.el(r, c)
+			if x == nil {
+				continue
+			}
+			if d := m.den(r, c); d != nil {
+				n.SetFrac(r, c, &terms.Frac{Num: terms.Mul(x, e), Den: d})
+				continue
+			}
+			n.Set(r, c, terms.Mul(x, e))
+		}
+	}
+	return n
+}
+
+// Kron returns the Kronecker product of m and n. This is a
+// (m.rows*n.rows)x(m.cols*n.cols) matrix made up of blocks, one for
+// each element of m, where each block is that element times n.
+func (m *Matrix) Kron(n *Matrix) *Matrix {
+	k, _ := NewMatrix(m.rows*n.rows, m.cols*n.cols)
+	frac := m.fractional() || n.fractional()
+	for r := 0; r < m.rows; r++ {
+		for c := 0; c < m.cols; c++ {
+			x := m.el(r, c)
+			if x == nil {
+				continue
+			}
+			for i := 0; i < n.rows; i++ {
+				for j := 0; j < n.cols; j++ {
+					y := n.el(i, j)
+					if y == nil {
+						continue
+					}
+					if frac {
+						k.SetFrac(r*n.rows+i, c*n.cols+j, fracMul(m.Frac(r, c), n.Frac(i, j)))
+						continue
+					}
+					k.Set(r*n.rows+i, c*n.cols+j, terms.Mul(x, y))
+				}
+			}
+		}
+	}
+	return k
+}
+
+// place copies the elements of n into m with n's [0,0] element at
+// m's [row,col]. The element expressions are shared, not copied.
+func (m *Matrix) place(row, col int, n *Matrix) {
+	if n.dens != nil && m.dens == nil {
+		m.dens = make([]*terms.Exp, len(m.data))
+	}
+	for r := 0; r < n.rows; r++ {
+		i, j := col+m.cols*(row+r), n.cols*r
+		copy(m.data[i:i+n.cols], n.data[j:j+n.cols])
+		if n.dens != nil {
+			copy(m.dens[i:i+n.cols], n.dens[j:j+n.cols])
+		}
+	}
+}
+
+// AugmentRight returns the matrix [m | n], made by joining n to the
+// right hand side of m. Both must have the same number of rows.
+func (m *Matrix) AugmentRight(n *Matrix) (*Matrix, error) {
+	if m.rows != n.rows {
+		return nil, fmt.Errorf("a rows(%d) != b rows(%d)", m.rows, n.rows)
+	}
+	a, _ := NewMatrix(m.rows, m.cols+n.cols)
+	a.place(0, 0, m)
+	a.place(0, m.cols, n)
+	return a, nil
+}
+
+// StackBelow returns the matrix made by joining n below m. Both must
+// have the same number of columns.
+func (m *Matrix) StackBelow(n *Matrix) (*Matrix, error) {
+	if m.cols != n.cols {
+		return nil, fmt.Errorf("a cols(%d) != b cols(%d)", m.cols, n.cols)
+	}
+	a, _ := NewMatrix(m.rows+n.rows, m.cols)
+	a.place(0, 0, m)
+	a.place(m.rows, 0, n)
+	return a, nil
+}
+
+// pick returns the matrix made up of the listed rows and cols of m.
+// The element expressions are shared, not copied.
+func (m *Matrix) pick(rows, cols []int) *Matrix {
+	n, _ := NewMatrix(len(rows), len(cols))
+	if m.dens != nil {
+		n.dens = make([]*terms.Exp, len(n.data))
+	}
+	for i, r := range rows {
+		for j, c := range cols {
+			n.data[j+n.cols*i] = m.data[c+m.cols*r]
+			if m.dens != nil {
+				n.dens[j+n.cols*i] = m.dens[c+m.cols*r]
+			}
+		}
+	}
+	return n
+}
+
+// Submatrix returns the block of m made up of rows [r0,r1) and
+// cols [c0,c1).
+func (m *Matrix) Submatrix(r0, c0, r1, c1 int) (*Matrix, error) {
+	if r0 < 0 || c0 < 0 || r1 > m.rows || c1 > m.cols || r0 >= r1 || c0 >= c1 {
+		return nil, fmt.Errorf("bad block: [%d:%d,%d:%d] of %dx%d matrix", r0, r1, c0, c1, m.rows, m.cols)
+	}
+	var rows, cols []int
+	for r := r0; r < r1; r++ {
+		rows = append(rows, r)
+	}
+	for c := c0; c < c1; c++ {
+		cols = append(cols, c)
+	}
+	return m.pick(rows, cols), nil
+}
+
+// Minor returns the matrix m with the row, skipRow, and the column,
+// skipCol, removed.
+func (m *Matrix) Minor(skipRow, skipCol int) (*Matrix, error) {
+	if skipRow < 0 || skipCol < 0 || skipRow >= m.rows || skipCol >= m.cols {
+		return nil, fmt.Errorf("bad cell: [%d,%d] in %dx%d matrix", skipRow, skipCol, m.rows, m.cols)
+	}
+	if m.rows == 1 || m.cols == 1 {
+		return nil, fmt.Errorf("%dx%d matrix has no minors", m.rows, m.cols)
+	}
+	var rows, cols []int
+	for r := 0; r < m.rows; r++ {
+		if r != skipRow {
+			rows = append(rows, r)
+		}
+	}
+	for c := 0; c < m.cols; c++ {
+		if c != skipCol {
+			cols = append(cols, c)
+		}
+	}
+	return m.pick(rows, cols), nil
+}
+
+// vector returns the length of the vector (nx1 or 1xn matrix) m. An
+// error is returned if m is not a vector, or has fractional elements.
+func (m *Matrix) vector() (int, error) {
+	if m.rows != 1 && m.cols != 1 {
+		return 0, fmt.Errorf("%dx%d matrix is not a vector", m.rows, m.cols)
+	}
+	if m.fractional() {
+		return 0, fmt.Errorf("fractional vector not supported")
+	}
+	return len(m.data), nil
+}
+
+// at returns the i'th element of a vector as a non-nil expression.
+func (m *Matrix) at(i int) *terms.Exp {
+	return terms.Sum(m.data[i])
+}
+
+// Cross returns the cross product, a x b, of two 3-vectors. The
+// vectors can be 3x1 or 1x3 matrices, and the result has the shape
+// of a.
+func Cross(a, b *Matrix) (*Matrix, error) {
+	for _, v := range []*Matrix{a, b} {
+		if n, err := v.vector(); err != nil {
+			return nil, err
+		} else if n != 3 {
+			return nil, fmt.Errorf("need a 3-vector, not %dx%d", v.rows, v.cols)
+		}
+	}
+	c, _ := NewMatrix(a.rows, a.cols)
+	for i := 0; i < 3; i++ {
+		j, k := (i+1)%3, (i+2)%3
+		c.data[i] = terms.Mul(a.at(j), b.at(k)).Sub(terms.Mul(a.at(k), b.at(j)))
+	}
+	return c, nil
+}
+
+// Skew returns the 3x3 skew-symmetric matrix, [v]x, of the 3-vector
+// v. For any 3-vector w, [v]x*w is the cross product v x w.
+func Skew(v *Matrix) (*Matrix, error) {
+	if n, err := v.vector(); err != nil {
+		return nil, err
+	} else if n != 3 {
+		return nil, fmt.Errorf("need a 3-vector, not %dx%d", v.rows, v.cols)
+	}
+	m, _ := NewMatrix(3, 3)
+	for i := 0; i < 3; i++ {
+		j, k := (i+1)%3, (i+2)%3
+		m.Set(i, i, terms.NewExp())
+		m.Set(k, j, v.at(i))
+		m.Set(j, k, terms.Mul(minusOne, v.at(i)))
+	}
+	return m, nil
+}
+
+// Unskew returns the 3x1 vector, v, of the 3x3 skew-symmetric matrix
+// m = [v]x. It is the inverse of Skew. An error is returned if m is
+// not skew-symmetric.
+func Unskew(m *Matrix) (*Matrix, error) {
+	if m.rows != 3 || m.cols != 3 {
+		return nil, fmt.Errorf("need a 3x3 matrix, not %dx%d", m.rows, m.cols)
+	}
+	if m.fractional() {
+		return nil, fmt.Errorf("fractional matrix not supported")
+	}
+	v, _ := NewMatrix(3, 1)
+	for i := 0; i < 3; i++ {
+		j, k := (i+1)%3, (i+2)%3
+		if !m.El(i, i).IsZero() {
+			return nil, fmt.Errorf("non-zero diagonal element [%d,%d]", i, i)
+		}
+		x := terms.Sum(m.El(k, j))
+		if !x.Equals(terms.Mul(minusOne, terms.Sum(m.El(j, k)))) {
+			return nil, fmt.Errorf("elements [%d,%d] and [%d,%d] are not skew-symmetric", k, j, j, k)
+		}
+		v.Set(i, 0, x)
+	}
+	return v, nil
+}
+
+// Dot returns the dot product of two vectors of the same length. The
+// vectors can be nx1 or 1xn matrices.
+func Dot(a, b *Matrix) (*terms.Exp, error) {
+	n, err := a.vector()
+	if err != nil {
+		return nil, err
+	}
+	m, err := b.vector()
+	if err != nil {
+		return nil, err
+	}
+	if n != m {
+		return nil, fmt.Errorf("vector lengths differ: %d != %d", n, m)
+	}
+	var es []*terms.Exp
+	for i := 0; i < n; i++ {
+		if a.data[i] != nil && b.data[i] != nil {
+			es = append(es, terms.Mul(a.data[i], b.data[i]))
+		}
+	}
+	return terms.Sum(es...), nil
+}
+
+// NormSquared returns the sum of the squares of the elements of a
+// vector.
+func (m *Matrix) NormSquared() (*terms.Exp, error) {
+	return Dot(m, m)
+}
+
+// Map returns a new matrix of the same dimensions as m, with each
+// element replaced by the value of fn(r, c, e) where e is the
+// original r,c element. Zero elements may be passed to fn as nil.
+// Like El, Map panics if m holds an element with a non-trivial
+// denominator (see SetFrac).
+func (m *Matrix) Map(fn func(r, c int, e *terms.Exp) *terms.Exp) *Matrix {
+	n, _ := NewMatrix(m.rows, m.cols)
+	for r := 0; r < m.rows; r++ {
+		for c := 0; c < m.cols; c++ {
+			n.Set(r, c, fn(r, c, m.El(r, c)))
+		}
+	}
+	return n
+}
+
 // Performs a substitution on all elements of a matrix.
 func (m *Matrix) Substitute(b []factor.Value, s *terms.Exp) *Matrix {
 	n, _ := NewMatrix(m.rows, m.cols)
 	for r := 0; r < m.rows; r++ {
 		for c := 0; c < m.cols; c++ {
+			if d := m.den(r, c); d != nil {
+				n.SetFrac(r, c, &terms.Frac{
+					Num: m.el(r, c).Substitute(b, s),
+					Den: d.Substitute(b, s),
+				})
+				continue
+			}
 			n.Set(r, c, m.El(r, c).Substitute(b, s))
 		}
 	}
 	return n
 }
+
+// IsZero confirms that every element of m is zero. Unset, nil,
+// elements are zero.
+func (m *Matrix) IsZero() bool {
+	for _, e := range m.data {
+		if !e.IsZero() {
+			return false
+		}
+	}
+	return true
+}
+
+// equal compares the row,col element of m with the r,c element of n.
+// Nil elements are zero.
+func (m *Matrix) equal(row, col int, n *Matrix, r, c int) bool {
+	if m.den(row, col) == nil && n.den(r, c) == nil {
+		return m.Frac(row, col).Num.Equals(n.Frac(r, c).Num)
+	}
+	return m.Frac(row, col).Equals(n.Frac(r, c))
+}
+
+// Equals confirms that m and n have the same dimensions and that all
+// of their corresponding elements are equal. Nil elements are zero.
+func (m *Matrix) Equals(n *Matrix) bool {
+	if m.rows != n.rows || m.cols != n.cols {
+		return false
+	}
+	for r := 0; r < m.rows; r++ {
+		for c := 0; c < m.cols; c++ {
+			if !m.equal(r, c, n, r, c) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// IsDiagonal confirms that all of the elements of m off its leading
+// diagonal are zero.
+func (m *Matrix) IsDiagonal() bool {
+	for r := 0; r < m.rows; r++ {
+		for c := 0; c < m.cols; c++ {
+			if r != c && !m.el(r, c).IsZero() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// IsIdentity confirms that m is a square diagonal matrix with all of
+// its diagonal elements equal to 1.
+func (m *Matrix) IsIdentity() bool {
+	if m.rows != m.cols || !m.IsDiagonal() {
+		return false
+	}
+	for i := 0; i < m.rows; i++ {
+		if !m.Frac(i, i).Equals(&terms.Frac{Num: one, Den: one}) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsSymmetric confirms that m is a square matrix equal to its
+// transpose.
+func (m *Matrix) IsSymmetric() bool {
+	if m.rows != m.cols {
+		return false
+	}
+	for r := 0; r < m.rows; r++ {
+		for c := r + 1; c < m.cols; c++ {
+			if !m.equal(r, c, m, c, r) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Trace returns the sum of the diagonal elements of a square matrix.
+func (m *Matrix) Trace() (*terms.Exp, error) {
+	if m.rows != m.cols {
+		return nil, fmt.Errorf("trace of non-square %dx%d matrix", m.rows, m.cols)
+	}
+	var es []*terms.Exp
+	for i := 0; i < m.rows; i++ {
+		if m.den(i, i) != nil {
+			return nil, fmt.Errorf("unsupported fractional element [%d,%d]", i, i)
+		}
+		es = append(es, m.El(i, i))
+	}
+	return terms.Sum(es...), nil
+}
+
+// det computes the determinant of the sub-matrix of m made up of
+// the listed rows and cols by cofactor expansion along the first
+// listed row. The determinants of minors are cached in memo, indexed
+// by their rows and cols.
+func (m *Matrix) det(memo map[string]*terms.Exp, rows, cols []int) *terms.Exp {
+	if len(rows) == 1 {
+		return terms.Sum(m.El(rows[0], cols[0]))
+	}
+	key := fmt.Sprint(rows, cols)
+	if d, ok := memo[key]; ok {
+		return d
+	}
+	var es []*terms.Exp
+	for i, c := range cols {
+		x := m.El(rows[0], c)
+		if x.IsZero() {
+			continue
+		}
+		var sub []int
+		sub = append(sub, cols[:i]...)
+		sub = append(sub, cols[i+1:]...)
+		e := terms.Mul(x, m.det(memo, rows[1:], sub))
+		if i%2 == 1 {
+			e = terms.Mul(e, minusOne)
+		}
+		es = append(es, e)
+	}
+	d := terms.Sum(es...)
+	memo[key] = d
+	return d
+}
+
+// Determinant computes the determinant of a square matrix.
+func (m *Matrix) Determinant() (*terms.Exp, error) {
+	return m.DeterminantLaplace(0)
+}
+
+// DeterminantLaplace computes the determinant of a square matrix by
+// cofactor expansion along the row, alongRow. Picking the row with
+// the most zero elements minimizes the work. The determinants of the
+// minors are computed recursively, and each distinct minor is only
+// computed once.
+func (m *Matrix) DeterminantLaplace(alongRow int) (*terms.Exp, error) {
+	if m.rows != m.cols {
+		return nil, fmt.Errorf("non-square %dx%d matrix has no determinant", m.rows, m.cols)
+	}
+	if m.fractional() {
+		return nil, fmt.Errorf("determinant of fractional matrix not supported")
+	}
+	if alongRow < 0 || alongRow >= m.rows {
+		return nil, fmt.Errorf("bad row %d in %dx%d matrix", alongRow, m.rows, m.cols)
+	}
+	rows := []int{alongRow}
+	var cols []int
+	for i := 0; i < m.rows; i++ {
+		if i != alongRow {
+			rows = append(rows, i)
+		}
+		cols = append(cols, i)
+	}
+	d := m.det(make(map[string]*terms.Exp), rows, cols)
+	if alongRow%2 == 1 {
+		d = terms.Mul(d, minusOne)
+	}
+	return d, nil
+}
+
+// Inverse returns the inverse of a square matrix. It is computed as
+// the adjugate of m divided by the determinant of m. Elements that
+// cannot be simplified to a single expression retain a denominator
+// (see Frac).
+func (m *Matrix) Inverse() (*Matrix, error) {
+	d, err := m.Determinant()
+	if err != nil {
+		return nil, err
+	}
+	if d.IsZero() {
+		return nil, fmt.Errorf("singular matrix has no inverse: %v", m)
+	}
+	n, _ := NewMatrix(m.rows, m.cols)
+	if m.rows == 1 {
+		n.SetFrac(0, 0, terms.NewFrac(one, d))
+		return n, nil
+	}
+	memo := make(map[string]*terms.Exp)
+	for r := 0; r < m.rows; r++ {
+		for c := 0; c < m.cols; c++ {
+			// The adjugate is the transpose of the cofactor matrix.
+			var rows, cols []int
+			for i := 0; i < m.rows; i++ {
+				if i != c {
+					rows = append(rows, i)
+				}
+				if i != r {
+					cols = append(cols, i)
+				}
+			}
+			cof := m.det(memo, rows, cols)
+			if (r+c)%2 == 1 {
+				cof = terms.Mul(cof, minusOne)
+			}
+			n.SetFrac(r, c, terms.NewFrac(cof, d))
+		}
+	}
+	return n, nil
+}
+
+// cramer solves the square system a*x = b for x using Cramer's rule.
+// Each column of b is solved independently.
+func cramer(a, b *Matrix) (*Matrix, error) {
+	if a.rows != a.cols {
+		return nil, fmt.Errorf("non-square %dx%d system matrix", a.rows, a.cols)
+	}
+	if a.rows != b.rows {
+		return nil, fmt.Errorf("a rows(%d) != b rows(%d)", a.rows, b.rows)
+	}
+	if b.fractional() {
+		return nil, fmt.Errorf("fractional constant matrix not supported")
+	}
+	d, err := a.Determinant()
+	if err != nil {
+		return nil, err
+	}
+	if d.IsZero() {
+		return nil, fmt.Errorf("singular system matrix: %v", a)
+	}
+	x, _ := NewMatrix(a.cols, b.cols)
+	for j := 0; j < b.cols; j++ {
+		for i := 0; i < a.cols; i++ {
+			// Replace column i of a with column j of b.
+			ai, _ := NewMatrix(a.rows, a.cols)
+			copy(ai.data, a.data)
+			for r := 0; r < a.rows; r++ {
+				ai.Set(r, i, b.El(r, j))
+			}
+			di, err := ai.Determinant()
+			if err != nil {
+				return nil, err
+			}
+			x.SetFrac(i, j, terms.NewFrac(di, d))
+		}
+	}
+	return x, nil
+}
+
+// SolveLeastSquares finds the x that minimizes the squared residual
+// of a*x = b. It does this by solving the normal equations,
+// transpose(a)*a*x = transpose(a)*b, for x. When a is square and
+// invertible, this is the exact solution of a*x = b.
+func SolveLeastSquares(a, b *Matrix) (*Matrix, error) {
+	if a.rows != b.rows {
+		return nil, fmt.Errorf("a rows(%d) != b rows(%d)", a.rows, b.rows)
+	}
+	at := a.Transpose()
+	ata, err := at.Mul(a)
+	if err != nil {
+		return nil, err
+	}
+	atb, err := at.Mul(b)
+	if err != nil {
+		return nil, err
+	}
+	return cramer(ata, atb)
+}
+
+// reduced returns a reduced copy of the fraction f.
+func reduced(f *terms.Frac) *terms.Frac {
+	if f.Num.IsZero() {
+		return &terms.Frac{Num: terms.NewExp(), Den: one}
+	}
+	g := &terms.Frac{Num: f.Num, Den: f.Den}
+	g.Reduce()
+	return g
+}
+
+// RREF returns the reduced row echelon form of m. The row reduction
+// is performed with fractions of expressions, and the pivot of each
+// column is the first remaining row whose element is not zero. Since
+// the elements are symbolic, the pivots are assumed to be non-zero
+// for all values of their symbols.
+func (m *Matrix) RREF() (*Matrix, error) {
+	fs := make([][]*terms.Frac, m.rows)
+	for r := range fs {
+		fs[r] = make([]*terms.Frac, m.cols)
+		for c := range fs[r] {
+			fs[r][c] = reduced(m.Frac(r, c))
+		}
+	}
+	p := 0
+	for c := 0; c < m.cols && p < m.rows; c++ {
+		r := p
+		for r < m.rows && fs[r][c].Num.IsZero() {
+			r++
+		}
+		if r == m.rows {
+			continue
+		}
+		fs[p], fs[r] = fs[r], fs[p]
+		inv := &terms.Frac{Num: fs[p][c].Den, Den: fs[p][c].Num}
+		for j := c; j < m.cols; j++ {
+			fs[p][j] = reduced(fracMul(fs[p][j], inv))
+		}
+		for i := 0; i < m.rows; i++ {
+			if i == p || fs[i][c].Num.IsZero() {
+				continue
+			}
+			k := fracMul(fs[i][c], &terms.Frac{Num: minusOne, Den: one})
+			for j := c; j < m.cols; j++ {
+				fs[i][j] = reduced(fracAdd(fs[i][j], fracMul(k, fs[p][j])))
+			}
+		}
+		p++
+	}
+	n, _ := NewMatrix(m.rows, m.cols)
+	for r := range fs {
+		for c, f := range fs[r] {
+			if err := n.SetFrac(r, c, f); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// SolveSystem solves m*x = b for the column vector x, where m is a
+// square matrix and b is a column vector of the same height. An
+// error is returned if m is singular.
+func (m *Matrix) SolveSystem(b *Matrix) (*Matrix, error) {
+	if m.rows != m.cols {
+		return nil, fmt.Errorf("non-square %dx%d system matrix", m.rows, m.cols)
+	}
+	if b.rows != m.rows || b.cols != 1 {
+		return nil, fmt.Errorf("need %dx1 vector, not %dx%d", m.rows, b.rows, b.cols)
+	}
+	a, err := m.AugmentRight(b)
+	if err != nil {
+		return nil, err
+	}
+	if a, err = a.RREF(); err != nil {
+		return nil, err
+	}
+	x, _ := NewMatrix(m.rows, 1)
+	for r := 0; r < m.rows; r++ {
+		if f := a.Frac(r, r); !f.Num.Equals(f.Den) {
+			return nil, fmt.Errorf("singular system matrix: %v", m)
+		}
+		x.SetFrac(r, 0, a.Frac(r, m.cols))
+	}
+	return x, nil
+}
+
+// SubstituteMatrix replaces each element of m that is exactly the
+// symbol sym with a copy of the matrix block. The result has
+// dimensions (rows*block.rows)x(cols*block.cols): every element of m
+// is expanded into a block of this size. Zero elements expand to a
+// zero block. All other elements must not contain sym, and can only
+// be expanded when block is square: they become that element times
+// the identity block.
+func (m *Matrix) SubstituteMatrix(sym factor.Value, block *Matrix) (*Matrix, error) {
+	x := terms.NewExp([]factor.Value{factor.S(sym.Symbol())})
+	br, bc := block.rows, block.cols
+	n, err := NewMatrix(m.rows*br, m.cols*bc)
+	if err != nil {
+		return nil, err
+	}
+	for r := 0; r < m.rows; r++ {
+		for c := 0; c < m.cols; c++ {
+			f := m.Frac(r, c)
+			if f.Num.IsZero() {
+				continue
+			}
+			if m.den(r, c) == nil && f.Num.Equals(x) {
+				for i := 0; i < br; i++ {
+					for j := 0; j < bc; j++ {
+						if block.den(i, j) != nil {
+							n.SetFrac(r*br+i, c*bc+j, block.Frac(i, j))
+						} else {
+							n.Set(r*br+i, c*bc+j, block.El(i, j))
+						}
+					}
+				}
+				continue
+			}
+			for _, s := range append(f.Num.Symbols(), f.Den.Symbols()...) {
+				if s.Symbol() == sym.Symbol() {
+					return nil, fmt.Errorf("element [%d,%d] = %v is not just %v", r, c, f, x)
+				}
+			}
+			if br != bc {
+				return nil, fmt.Errorf("element [%d,%d] = %v needs a square block, not %dx%d", r, c, f, br, bc)
+			}
+			for i := 0; i < br; i++ {
+				n.SetFrac(r*br+i, c*bc+i, f)
+			}
+		}
+	}
+	return n, nil
+}
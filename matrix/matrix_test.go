@@ -114,3 +114,650 @@ func TestSum(t *testing.T) {
 		t.Errorf("add: got=%q, want=%q", got, want)
 	}
 }
+
+// symbolic returns a rows x cols matrix with elements named by
+// successive letters of names.
+func symbolic(t *testing.T, rows, cols int, names string) *Matrix {
+	m, err := NewMatrix(rows, cols)
+	if err != nil {
+		t.Fatalf("failed to make %dx%d matrix: %v", rows, cols, err)
+	}
+	for i := 0; i < rows*cols; i++ {
+		v, err := terms.ParseExp(names[i : i+1])
+		if err != nil {
+			t.Fatalf("bad element %q: %v", names[i:i+1], err)
+		}
+		m.Set(i/cols, i%cols, v)
+	}
+	return m
+}
+
+func TestDeterminant(t *testing.T) {
+	vs := []struct {
+		m    *Matrix
+		want string
+	}{
+		{m: symbolic(t, 1, 1, "a"), want: "a"},
+		{m: symbolic(t, 2, 2, "abcd"), want: "a*d-b*c"},
+		{m: symbolic(t, 3, 3, "abcdefghi"), want: "a*e*i-a*f*h-b*d*i+b*f*g+c*d*h-c*e*g"},
+	}
+	for i, v := range vs {
+		d, err := v.m.Determinant()
+		if err != nil {
+			t.Errorf("[%d] determinant of %v failed: %v", i, v.m, err)
+			continue
+		}
+		if got := d.String(); got != v.want {
+			t.Errorf("[%d] got=%q, want=%q", i, got, v.want)
+		}
+	}
+	if _, err := symbolic(t, 2, 3, "abcdef").Determinant(); err == nil {
+		t.Error("non-square matrix has a determinant")
+	}
+
+	m := symbolic(t, 4, 4, "abcdefghijklmnop")
+	d, err := m.Determinant()
+	if err != nil {
+		t.Fatalf("determinant of %v failed: %v", m, err)
+	}
+	for r := 0; r < 4; r++ {
+		if e, err := m.DeterminantLaplace(r); err != nil {
+			t.Errorf("[%d] laplace expansion failed: %v", r, err)
+		} else if !e.Equals(d) {
+			t.Errorf("[%d] laplace expansion: got=%v, want=%v", r, e, d)
+		}
+	}
+	s := symbolic(t, 3, 3, "a0bc0de0f")
+	if e, err := s.DeterminantLaplace(1); err != nil {
+		t.Errorf("sparse laplace expansion failed: %v", err)
+	} else if got, want := e.String(), "0"; got != want {
+		t.Errorf("sparse laplace expansion: got=%q, want=%q", got, want)
+	}
+	if _, err := m.DeterminantLaplace(4); err == nil {
+		t.Error("expanded along a missing row")
+	}
+}
+
+func TestInverse(t *testing.T) {
+	m := symbolic(t, 2, 2, "abcd")
+	n, err := m.Inverse()
+	if err != nil {
+		t.Fatalf("failed to invert %v: %v", m, err)
+	}
+	if got, want := n.String(), "[[d/(a*d-b*c), -b/(a*d-b*c)], [-c/(a*d-b*c), a/(a*d-b*c)]]"; got != want {
+		t.Errorf("inverse: got=%q, want=%q", got, want)
+	}
+	if got, want := m.Mx(n).String(), "[[1, 0], [0, 1]]"; got != want {
+		t.Errorf("m*m^-1: got=%q, want=%q", got, want)
+	}
+	if got, want := n.Mx(m).String(), "[[1, 0], [0, 1]]"; got != want {
+		t.Errorf("m^-1*m: got=%q, want=%q", got, want)
+	}
+
+	d, _ := Identity(2)
+	d.Set(0, 0, terms.NewExp([]factor.Value{factor.D(2, 1)}))
+	d.Set(1, 1, terms.NewExp([]factor.Value{factor.S("x")}))
+	if e, err := d.Inverse(); err != nil {
+		t.Errorf("failed to invert %v: %v", d, err)
+	} else if got, want := e.String(), "[[1/2, 0], [0, x^-1]]"; got != want {
+		t.Errorf("diagonal inverse: got=%q, want=%q", got, want)
+	}
+
+	s := symbolic(t, 2, 2, "abab")
+	if _, err := s.Inverse(); err == nil {
+		t.Errorf("singular matrix %v was inverted", s)
+	}
+
+	if got, want := n.Frac(0, 1).String(), "-b/(a*d-b*c)"; got != want {
+		t.Errorf("frac: got=%q, want=%q", got, want)
+	}
+	if got, want := n.Transpose().Frac(1, 0).String(), "-b/(a*d-b*c)"; got != want {
+		t.Errorf("transposed frac: got=%q, want=%q", got, want)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("El of fractional element did not panic")
+		}
+	}()
+	n.El(0, 1)
+}
+
+func TestSolveLeastSquares(t *testing.T) {
+	// Fit y = p + q*x through (0,1), (1,3), (2,a).
+	a, _ := NewMatrix(3, 2)
+	b, _ := NewMatrix(3, 1)
+	for i, y := range []string{"1", "3", "a"} {
+		a.Set(i, 0, one)
+		if i != 0 {
+			a.Set(i, 1, terms.NewExp([]factor.Value{factor.D(int64(i), 1)}))
+		}
+		v, err := terms.ParseExp(y)
+		if err != nil {
+			t.Fatalf("bad value %q: %v", y, err)
+		}
+		b.Set(i, 0, v)
+	}
+	x, err := SolveLeastSquares(a, b)
+	if err != nil {
+		t.Fatalf("failed to solve: %v", err)
+	}
+	if got, want := x.String(), "[[11/6-1/6*a], [-1/2+1/2*a]]"; got != want {
+		t.Errorf("least squares: got=%q, want=%q", got, want)
+	}
+	// A consistent system recovers the exact answer.
+	ans := x.Substitute([]factor.Value{factor.S("a")}, terms.NewExp([]factor.Value{factor.D(5, 1)}))
+	if got, want := ans.String(), "[[1], [2]]"; got != want {
+		t.Errorf("exact fit: got=%q, want=%q", got, want)
+	}
+	if _, err := SolveLeastSquares(a, symbolic(t, 2, 1, "ab")); err == nil {
+		t.Error("mismatched dimensions solved")
+	}
+}
+
+func TestSubstituteMatrix(t *testing.T) {
+	m, _ := Identity(2)
+	m.Set(0, 0, terms.NewExp([]factor.Value{factor.S("R")}))
+	m.Set(1, 1, terms.NewExp([]factor.Value{factor.S("k")}))
+	block := symbolic(t, 2, 2, "abcd")
+	n, err := m.SubstituteMatrix(factor.S("R"), block)
+	if err != nil {
+		t.Fatalf("failed to substitute: %v", err)
+	}
+	if got, want := n.String(), "[[a, b, 0, 0], [c, d, 0, 0], [0, 0, k, 0], [0, 0, 0, k]]"; got != want {
+		t.Errorf("block substitution: got=%q, want=%q", got, want)
+	}
+	v := symbolic(t, 2, 1, "RR")
+	if _, err := v.SubstituteMatrix(factor.S("R"), symbolic(t, 1, 2, "ab")); err != nil {
+		t.Errorf("failed to substitute non-square block: %v", err)
+	}
+	if _, err := m.SubstituteMatrix(factor.S("R"), symbolic(t, 1, 2, "ab")); err == nil {
+		t.Error("scaled identity for non-square block")
+	}
+	m.Set(1, 0, terms.NewExp([]factor.Value{factor.S("R"), factor.S("k")}))
+	if _, err := m.SubstituteMatrix(factor.S("R"), block); err == nil {
+		t.Error("substituted a product containing the symbol")
+	}
+}
+
+func TestScale(t *testing.T) {
+	m, _ := NewMatrix(2, 3)
+	m.Set(0, 0, terms.NewExp([]factor.Value{factor.S("a")}))
+	m.Set(1, 2, terms.NewExp([]factor.Value{factor.D(1, 2), factor.S("b")}))
+	x := terms.NewExp([]factor.Value{factor.D(2, 1), factor.S("x")})
+	if got, want := m.Scale(x).String(), "[[2*a*x, 0, 0], [0, 0, b*x]]"; got != want {
+		t.Errorf("scale: got=%q, want=%q", got, want)
+	}
+	if got, want := m.Scale(terms.NewExp()).String(), "[[0, 0, 0], [0, 0, 0]]"; got != want {
+		t.Errorf("zero scale: got=%q, want=%q", got, want)
+	}
+	n, err := symbolic(t, 2, 2, "abcd").Inverse()
+	if err != nil {
+		t.Fatalf("failed to invert: %v", err)
+	}
+	det, _ := terms.ParseExp("a*d-b*c")
+	if got, want := n.Scale(det).String(), "[[d, -b], [-c, a]]"; got != want {
+		t.Errorf("adjugate: got=%q, want=%q", got, want)
+	}
+}
+
+func TestTrace(t *testing.T) {
+	id, _ := Identity(3)
+	if e, err := id.Trace(); err != nil {
+		t.Errorf("failed to trace %v: %v", id, err)
+	} else if got, want := e.String(), "3"; got != want {
+		t.Errorf("identity trace: got=%q, want=%q", got, want)
+	}
+	m := symbolic(t, 2, 2, "abcd")
+	if e, err := m.Trace(); err != nil {
+		t.Errorf("failed to trace %v: %v", m, err)
+	} else if got, want := e.String(), "a+d"; got != want {
+		t.Errorf("trace: got=%q, want=%q", got, want)
+	}
+	z, _ := NewMatrix(2, 2)
+	if e, err := z.Trace(); err != nil {
+		t.Errorf("failed to trace %v: %v", z, err)
+	} else if got, want := e.String(), "0"; got != want {
+		t.Errorf("zero trace: got=%q, want=%q", got, want)
+	}
+	if _, err := symbolic(t, 2, 3, "abcdef").Trace(); err == nil {
+		t.Error("traced a non-square matrix")
+	}
+}
+
+func TestRREF(t *testing.T) {
+	vs := []struct {
+		m    *Matrix
+		want string
+	}{
+		{symbolic(t, 2, 2, "abcd"), "[[1, 0], [0, 1]]"},
+		{symbolic(t, 2, 3, "abxcdy"), "[[1, 0, (-b*y+d*x)/(a*d-b*c)], [0, 1, (a*y-c*x)/(a*d-b*c)]]"},
+		{symbolic(t, 2, 2, "abab"), "[[1, a^-1*b], [0, 0]]"},
+		{symbolic(t, 2, 3, "0ab0cd"), "[[0, 1, 0], [0, 0, 1]]"},
+		{symbolic(t, 2, 3, "0ab0ab"), "[[0, 1, a^-1*b], [0, 0, 0]]"},
+	}
+	for i, v := range vs {
+		n, err := v.m.RREF()
+		if err != nil {
+			t.Errorf("[%d] failed to reduce %v: %v", i, v.m, err)
+			continue
+		}
+		if got := n.String(); got != v.want {
+			t.Errorf("[%d] rref of %v: got=%q, want=%q", i, v.m, got, v.want)
+		}
+	}
+}
+
+func TestSolveSystem(t *testing.T) {
+	m := symbolic(t, 2, 2, "abcd")
+	b := symbolic(t, 2, 1, "xy")
+	x, err := m.SolveSystem(b)
+	if err != nil {
+		t.Fatalf("failed to solve: %v", err)
+	}
+	if got, want := x.String(), "[[(-b*y+d*x)/(a*d-b*c)], [(a*y-c*x)/(a*d-b*c)]]"; got != want {
+		t.Errorf("solve: got=%q, want=%q", got, want)
+	}
+	if got, want := m.Mx(x).String(), b.String(); got != want {
+		t.Errorf("m*x: got=%q, want=%q", got, want)
+	}
+
+	// 2p+q = 5, p-q = 1.
+	n, _ := NewMatrix(2, 2)
+	n.Set(0, 0, terms.NewExp([]factor.Value{factor.D(2, 1)}))
+	n.Set(0, 1, terms.NewExp([]factor.Value{factor.D(1, 1)}))
+	n.Set(1, 0, terms.NewExp([]factor.Value{factor.D(1, 1)}))
+	n.Set(1, 1, terms.NewExp([]factor.Value{factor.D(-1, 1)}))
+	c, _ := NewMatrix(2, 1)
+	c.Set(0, 0, terms.NewExp([]factor.Value{factor.D(5, 1)}))
+	c.Set(1, 0, terms.NewExp([]factor.Value{factor.D(1, 1)}))
+	if x, err := n.SolveSystem(c); err != nil {
+		t.Errorf("failed to solve numerical system: %v", err)
+	} else if got, want := x.String(), "[[2], [1]]"; got != want {
+		t.Errorf("numerical solve: got=%q, want=%q", got, want)
+	}
+
+	if _, err := symbolic(t, 2, 2, "abab").SolveSystem(b); err == nil {
+		t.Error("solved a singular system")
+	}
+	if _, err := m.SolveSystem(symbolic(t, 1, 2, "xy")); err == nil {
+		t.Error("solved with a row vector")
+	}
+	if _, err := symbolic(t, 2, 3, "abcdef").SolveSystem(b); err == nil {
+		t.Error("solved a non-square system")
+	}
+}
+
+func TestCross(t *testing.T) {
+	x := symbolic(t, 3, 1, "abc")
+	y := symbolic(t, 1, 3, "def")
+	c, err := Cross(x, y)
+	if err != nil {
+		t.Fatalf("cross product failed: %v", err)
+	}
+	if got, want := c.String(), "[[b*f-c*e], [-a*f+c*d], [a*e-b*d]]"; got != want {
+		t.Errorf("cross: got=%q, want=%q", got, want)
+	}
+	if c, err = Cross(y, y); err != nil {
+		t.Errorf("cross product failed: %v", err)
+	} else if got, want := c.String(), "[[0, 0, 0]]"; got != want {
+		t.Errorf("self cross: got=%q, want=%q", got, want)
+	}
+	z, _ := NewMatrix(3, 1)
+	z.Set(2, 0, terms.NewExp([]factor.Value{factor.D(1, 1)}))
+	if c, err = Cross(z, x); err != nil {
+		t.Errorf("cross product failed: %v", err)
+	} else if got, want := c.String(), "[[-b], [a], [0]]"; got != want {
+		t.Errorf("z cross: got=%q, want=%q", got, want)
+	}
+	if _, err := Cross(x, symbolic(t, 2, 1, "ab")); err == nil {
+		t.Error("cross product of a 2-vector")
+	}
+	if _, err := Cross(symbolic(t, 3, 3, "abcdefghi"), x); err == nil {
+		t.Error("cross product of a matrix")
+	}
+}
+
+func TestSkew(t *testing.T) {
+	v := symbolic(t, 3, 1, "abc")
+	s, err := Skew(v)
+	if err != nil {
+		t.Fatalf("skew failed: %v", err)
+	}
+	if got, want := s.String(), "[[0, -c, b], [c, 0, -a], [-b, a, 0]]"; got != want {
+		t.Errorf("skew: got=%q, want=%q", got, want)
+	}
+	w := symbolic(t, 3, 1, "def")
+	sw, err := s.Mul(w)
+	if err != nil {
+		t.Fatalf("multiply failed: %v", err)
+	}
+	if c, _ := Cross(v, w); !sw.Equals(c) {
+		t.Errorf("skew(v)*w=%v, cross(v,w)=%v", sw, c)
+	}
+	u, err := Unskew(s)
+	if err != nil {
+		t.Fatalf("unskew failed: %v", err)
+	}
+	if !u.Equals(v) {
+		t.Errorf("unskew: got=%v, want=%v", u, v)
+	}
+	if s, err = Skew(symbolic(t, 1, 3, "xyz")); err != nil {
+		t.Errorf("skew of a row vector failed: %v", err)
+	} else if u, _ := Unskew(s); !u.Equals(symbolic(t, 3, 1, "xyz")) {
+		t.Errorf("unskew of row vector skew: got=%v", u)
+	}
+	if _, err := Skew(symbolic(t, 2, 1, "ab")); err == nil {
+		t.Error("skew of a 2-vector")
+	}
+	if _, err := Unskew(symbolic(t, 2, 2, "0ab0")); err == nil {
+		t.Error("unskew of a 2x2 matrix")
+	}
+	if _, err := Unskew(symbolic(t, 3, 3, "abcdefghi")); err == nil {
+		t.Error("unskew of a general matrix")
+	}
+}
+
+func TestDot(t *testing.T) {
+	x := symbolic(t, 3, 1, "abc")
+	y := symbolic(t, 1, 3, "d0f")
+	if e, err := Dot(x, y); err != nil {
+		t.Errorf("dot product failed: %v", err)
+	} else if got, want := e.String(), "a*d+c*f"; got != want {
+		t.Errorf("dot: got=%q, want=%q", got, want)
+	}
+	if c, err := Cross(x, y); err != nil {
+		t.Errorf("cross product failed: %v", err)
+	} else if e, err := Dot(c, x); err != nil {
+		t.Errorf("dot product failed: %v", err)
+	} else if got, want := e.String(), "0"; got != want {
+		t.Errorf("orthogonal dot: got=%q, want=%q", got, want)
+	}
+	if _, err := Dot(x, symbolic(t, 2, 1, "ab")); err == nil {
+		t.Error("dot product of unequal vectors")
+	}
+	if _, err := Dot(symbolic(t, 2, 2, "abcd"), symbolic(t, 4, 1, "abcd")); err == nil {
+		t.Error("dot product of a matrix")
+	}
+}
+
+func TestNormSquared(t *testing.T) {
+	if e, err := symbolic(t, 1, 3, "a0c").NormSquared(); err != nil {
+		t.Errorf("norm failed: %v", err)
+	} else if got, want := e.String(), "a^2+c^2"; got != want {
+		t.Errorf("norm: got=%q, want=%q", got, want)
+	}
+	if _, err := symbolic(t, 2, 2, "abcd").NormSquared(); err == nil {
+		t.Error("norm of a matrix")
+	}
+}
+
+func TestKron(t *testing.T) {
+	i2, _ := Identity(2)
+	i4, _ := Identity(4)
+	if got, want := i2.Kron(i2).String(), i4.String(); got != want {
+		t.Errorf("identity kron: got=%q, want=%q", got, want)
+	}
+	a := symbolic(t, 1, 2, "a0")
+	b := symbolic(t, 2, 1, "xy")
+	if got, want := a.Kron(b).String(), "[[a*x, 0], [a*y, 0]]"; got != want {
+		t.Errorf("kron: got=%q, want=%q", got, want)
+	}
+	f, _ := NewMatrix(1, 1)
+	ab, _ := terms.ParseExp("a+b")
+	f.SetFrac(0, 0, terms.NewFrac(one, ab))
+	if got, want := b.Kron(f).String(), "[[x/(a+b)], [y/(a+b)]]"; got != want {
+		t.Errorf("fractional kron: got=%q, want=%q", got, want)
+	}
+}
+
+func TestAugment(t *testing.T) {
+	a := symbolic(t, 2, 2, "abcd")
+	b := symbolic(t, 2, 1, "xy")
+	m, err := a.AugmentRight(b)
+	if err != nil {
+		t.Fatalf("augment failed: %v", err)
+	}
+	if got, want := m.String(), "[[a, b, x], [c, d, y]]"; got != want {
+		t.Errorf("augment: got=%q, want=%q", got, want)
+	}
+	if m, err = a.StackBelow(b.Transpose()); err != nil {
+		t.Fatalf("stack failed: %v", err)
+	}
+	if got, want := m.String(), "[[a, b], [c, d], [x, y]]"; got != want {
+		t.Errorf("stack: got=%q, want=%q", got, want)
+	}
+	f, _ := NewMatrix(1, 2)
+	ab, _ := terms.ParseExp("a+b")
+	f.SetFrac(0, 1, terms.NewFrac(one, ab))
+	if m, err = f.StackBelow(a); err != nil {
+		t.Fatalf("stack failed: %v", err)
+	}
+	if got, want := m.String(), "[[0, 1/(a+b)], [a, b], [c, d]]"; got != want {
+		t.Errorf("fractional stack: got=%q, want=%q", got, want)
+	}
+	if _, err := a.AugmentRight(b.Transpose()); err == nil {
+		t.Error("augmented mismatched rows")
+	}
+	if _, err := a.StackBelow(b); err == nil {
+		t.Error("stacked mismatched cols")
+	}
+}
+
+func TestSubmatrix(t *testing.T) {
+	m := symbolic(t, 3, 3, "abcdefghi")
+	vs := []struct {
+		r0, c0, r1, c1 int
+		want           string
+	}{
+		{0, 0, 3, 3, m.String()},
+		{1, 1, 3, 3, "[[e, f], [h, i]]"},
+		{0, 2, 3, 3, "[[c], [f], [i]]"},
+		{2, 0, 3, 2, "[[g, h]]"},
+	}
+	for i, v := range vs {
+		s, err := m.Submatrix(v.r0, v.c0, v.r1, v.c1)
+		if err != nil {
+			t.Errorf("[%d] submatrix failed: %v", i, err)
+			continue
+		}
+		if got := s.String(); got != v.want {
+			t.Errorf("[%d] got=%q, want=%q", i, got, v.want)
+		}
+	}
+	if _, err := m.Submatrix(1, 1, 1, 2); err == nil {
+		t.Error("empty submatrix")
+	}
+	if _, err := m.Submatrix(0, 0, 4, 2); err == nil {
+		t.Error("oversized submatrix")
+	}
+}
+
+func TestMinor(t *testing.T) {
+	m := symbolic(t, 3, 3, "abcdefghi")
+	d, _ := m.Determinant()
+	var es []*terms.Exp
+	for c := 0; c < 3; c++ {
+		n, err := m.Minor(0, c)
+		if err != nil {
+			t.Fatalf("minor failed: %v", err)
+		}
+		x, _ := n.Determinant()
+		if c == 1 {
+			x = terms.Mul(x, minusOne)
+		}
+		es = append(es, terms.Mul(m.El(0, c), x))
+	}
+	if got, want := terms.Sum(es...).String(), d.String(); got != want {
+		t.Errorf("cofactor expansion: got=%q, want=%q", got, want)
+	}
+	if n, err := m.Minor(1, 2); err != nil {
+		t.Errorf("minor failed: %v", err)
+	} else if got, want := n.String(), "[[a, b], [g, h]]"; got != want {
+		t.Errorf("minor: got=%q, want=%q", got, want)
+	}
+	if _, err := m.Minor(3, 0); err == nil {
+		t.Error("minor of missing row")
+	}
+	if _, err := symbolic(t, 1, 3, "abc").Minor(0, 0); err == nil {
+		t.Error("minor of a vector")
+	}
+}
+
+func TestMap(t *testing.T) {
+	m := symbolic(t, 2, 2, "a0xd")
+	m.Set(0, 1, nil)
+	x := factor.S("x")
+	n := m.Map(func(r, c int, e *terms.Exp) *terms.Exp {
+		if e == nil {
+			return terms.NewExp([]factor.Value{factor.D(int64(r+1), int64(c+1))})
+		}
+		return terms.Mul(e, e).Derivative(x)
+	})
+	if got, want := n.String(), "[[0, 1/2], [2*x, 0]]"; got != want {
+		t.Errorf("map: got=%q, want=%q", got, want)
+	}
+	if got, want := m.String(), "[[a, 0], [x, d]]"; got != want {
+		t.Errorf("map modified original: got=%q, want=%q", got, want)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("map of a fractional element did not panic")
+		}
+	}()
+	ab, _ := terms.ParseExp("a+b")
+	m.SetFrac(1, 1, &terms.Frac{Num: terms.NewExp([]factor.Value{x}), Den: ab})
+	m.Map(func(r, c int, e *terms.Exp) *terms.Exp { return e })
+}
+
+func TestEquals(t *testing.T) {
+	z, _ := NewMatrix(2, 3)
+	if !z.IsZero() {
+		t.Errorf("unset matrix is not zero: %v", z)
+	}
+	if !symbolic(t, 2, 2, "0000").IsZero() {
+		t.Error("zero elements are not zero")
+	}
+	if symbolic(t, 2, 2, "000a").IsZero() {
+		t.Error("non-zero element is zero")
+	}
+	a := symbolic(t, 2, 2, "ab0d")
+	b := symbolic(t, 2, 2, "abcd")
+	b.Set(1, 0, nil)
+	if !a.Equals(b) || !b.Equals(a) {
+		t.Errorf("%v != %v", a, b)
+	}
+	if a.Equals(symbolic(t, 2, 2, "abcd")) {
+		t.Errorf("%v == %v", a, symbolic(t, 2, 2, "abcd"))
+	}
+	if a.Equals(symbolic(t, 1, 4, "ab0d")) {
+		t.Error("differing dimensions are equal")
+	}
+	// Fractional elements compare by value.
+	f, _ := NewMatrix(1, 1)
+	ab, _ := terms.ParseExp("a+b")
+	f.SetFrac(0, 0, &terms.Frac{Num: terms.Mul(ab, ab), Den: ab})
+	g, _ := NewMatrix(1, 1)
+	g.SetFrac(0, 0, &terms.Frac{Num: ab, Den: one})
+	if !f.Equals(g) {
+		t.Errorf("%v != %v", f, g)
+	}
+	h, _ := NewMatrix(1, 1)
+	h.SetFrac(0, 0, &terms.Frac{Num: one, Den: ab})
+	if h.Equals(g) || g.Equals(h) {
+		t.Errorf("%v == %v", h, g)
+	}
+	if !h.Equals(h.Transpose()) {
+		t.Errorf("%v != itself", h)
+	}
+}
+
+func TestPredicates(t *testing.T) {
+	id, _ := Identity(3)
+	frac, _ := Identity(2)
+	ab, _ := terms.ParseExp("a+b")
+	frac.SetFrac(1, 1, &terms.Frac{Num: ab, Den: ab})
+	rect, _ := NewMatrix(2, 3)
+	rect.Set(0, 0, one)
+	rect.Set(1, 1, one)
+	vs := []struct {
+		m                      *Matrix
+		diag, ident, symmetric bool
+	}{
+		{id, true, true, true},
+		{frac, true, true, true},
+		{symbolic(t, 2, 2, "a00d"), true, false, true},
+		{symbolic(t, 2, 2, "abbd"), false, false, true},
+		{symbolic(t, 2, 2, "abcd"), false, false, false},
+		{symbolic(t, 2, 2, "1001"), true, true, true},
+		{symbolic(t, 2, 2, "1011"), false, false, false},
+		{rect, true, false, false},
+		{symbolic(t, 1, 2, "aa"), false, false, false},
+	}
+	for i, v := range vs {
+		if got := v.m.IsDiagonal(); got != v.diag {
+			t.Errorf("[%d] %v IsDiagonal: got=%v want=%v", i, v.m, got, v.diag)
+		}
+		if got := v.m.IsIdentity(); got != v.ident {
+			t.Errorf("[%d] %v IsIdentity: got=%v want=%v", i, v.m, got, v.ident)
+		}
+		if got := v.m.IsSymmetric(); got != v.symmetric {
+			t.Errorf("[%d] %v IsSymmetric: got=%v want=%v", i, v.m, got, v.symmetric)
+		}
+	}
+}
+
+// dense returns a size x size matrix of multi-term expressions.
+func dense(t testing.TB, size int, x, y string) *Matrix {
+	m, err := NewMatrix(size, size)
+	if err != nil {
+		t.Fatalf("failed to make %dx%d matrix: %v", size, size, err)
+	}
+	for r := 0; r < size; r++ {
+		for c := 0; c < size; c++ {
+			e, err := terms.ParseExp(fmt.Sprintf("%d*%s%d+%s%d-%d", r+1, x, c, y, r, c))
+			if err != nil {
+				t.Fatalf("bad element [%d,%d]: %v", r, c, err)
+			}
+			m.Set(r, c, e)
+		}
+	}
+	return m
+}
+
+func TestParallelMul(t *testing.T) {
+	a, b := dense(t, 10, "x", "y"), dense(t, 10, "u", "v")
+	serial, err := a.mul(b, false)
+	if err != nil {
+		t.Fatalf("serial multiply failed: %v", err)
+	}
+	parallel, err := a.mul(b, true)
+	if err != nil {
+		t.Fatalf("parallel multiply failed: %v", err)
+	}
+	if got, want := parallel.String(), serial.String(); got != want {
+		t.Errorf("parallel multiply: got=%q, want=%q", got, want)
+	}
+	f, _ := NewMatrix(2, 2)
+	ab, _ := terms.ParseExp("a+b")
+	f.SetFrac(0, 1, terms.NewFrac(one, ab))
+	f.Set(1, 0, ab)
+	serial, _ = f.mul(f, false)
+	parallel, _ = f.mul(f, true)
+	if got, want := parallel.String(), serial.String(); got != want {
+		t.Errorf("parallel fractional multiply: got=%q, want=%q", got, want)
+	}
+}
+
+func BenchmarkMul(b *testing.B) {
+	x, y := dense(b, 10, "x", "y"), dense(b, 10, "u", "v")
+	for i := 0; i < b.N; i++ {
+		x.mul(y, false)
+	}
+}
+
+func BenchmarkParallelMul(b *testing.B) {
+	x, y := dense(b, 10, "x", "y"), dense(b, 10, "u", "v")
+	for i := 0; i < b.N; i++ {
+		x.mul(y, true)
+	}
+}
@@ -5,6 +5,9 @@
 package rotation
 
 import (
+	"fmt"
+	"math"
+
 	"zappem.net/pub/math/algex/factor"
 	"zappem.net/pub/math/algex/matrix"
 	"zappem.net/pub/math/algex/terms"
@@ -61,3 +64,182 @@ func RZ(theta string) *matrix.Matrix {
 
 	return m
 }
+
+// Euler returns the rotation matrix composed of three elementary
+// rotations by the angles a, b and c. The axes of these rotations
+// are listed in order, for example "ZYX" for RZ(a)*RY(b)*RX(c).
+func Euler(order string, a, b, c string) (*matrix.Matrix, error) {
+	if len(order) != 3 {
+		return nil, fmt.Errorf("need three axes, not %q", order)
+	}
+	m, _ := matrix.Identity(3)
+	for i, theta := range []string{a, b, c} {
+		var r *matrix.Matrix
+		switch order[i] {
+		case 'X':
+			r = RX(theta)
+		case 'Y':
+			r = RY(theta)
+		case 'Z':
+			r = RZ(theta)
+		default:
+			return nil, fmt.Errorf("invalid axis %q in %q", order[i], order)
+		}
+		m = m.Mx(r)
+	}
+	return m, nil
+}
+
+// Translate returns a 4x4 homogeneous matrix for translating by the
+// vector (x,y,z).
+func Translate(x, y, z string) (*matrix.Matrix, error) {
+	m, _ := matrix.Identity(4)
+	for i, v := range []string{x, y, z} {
+		e, err := terms.ParseExp(v)
+		if err != nil {
+			return nil, fmt.Errorf("bad offset[%d]=%q: %v", i, v, err)
+		}
+		m.Set(i, 3, e)
+	}
+	return m, nil
+}
+
+// Homogeneous returns a 4x4 homogeneous matrix that combines the 3x3
+// rotation matrix, r, with a translation by the vector (x,y,z).
+func Homogeneous(r *matrix.Matrix, x, y, z string) (*matrix.Matrix, error) {
+	if rows, cols := r.Dims(); rows != 3 || cols != 3 {
+		return nil, fmt.Errorf("need 3x3 rotation, not %dx%d", rows, cols)
+	}
+	m, _ := matrix.Identity(4)
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			m.SetFrac(i, j, r.Frac(i, j))
+		}
+	}
+	for i, v := range []string{x, y, z} {
+		e, err := terms.ParseExp(v)
+		if err != nil {
+			return nil, fmt.Errorf("bad offset[%d]=%q: %v", i, v, err)
+		}
+		m.Set(i, 3, e)
+	}
+	return m, nil
+}
+
+// ApplyPoint transforms the point (x,y,z) with the 4x4 homogeneous
+// matrix, m. The point is extended to [x,y,z,1], multiplied by m and
+// the resulting 3x1 point is divided by the fourth, w, component of
+// the product. Unless w is 1, the elements are fractions.
+func ApplyPoint(m *matrix.Matrix, x, y, z string) (*matrix.Matrix, error) {
+	if rows, cols := m.Dims(); rows != 4 || cols != 4 {
+		return nil, fmt.Errorf("need 4x4 homogeneous matrix, not %dx%d", rows, cols)
+	}
+	v, _ := matrix.NewMatrix(4, 1)
+	for i, s := range []string{x, y, z} {
+		e, err := terms.ParseExp(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad point[%d]=%q: %v", i, s, err)
+		}
+		v.Set(i, 0, e)
+	}
+	v.Set(3, 0, one)
+	r, err := m.Mul(v)
+	if err != nil {
+		return nil, err
+	}
+	w := r.Frac(3, 0)
+	if w.Num.IsZero() {
+		return nil, fmt.Errorf("point at infinity, w=0")
+	}
+	p, _ := matrix.NewMatrix(3, 1)
+	for i := 0; i < 3; i++ {
+		if err := p.SetFrac(i, 0, r.Frac(i, 0).Div(w)); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Quat is a quaternion, W + X*i + Y*j + Z*k, with expression
+// components. A nil component is zero.
+type Quat struct {
+	W, X, Y, Z *terms.Exp
+}
+
+// parts returns the non-nil components of q.
+func (q Quat) parts() (w, x, y, z *terms.Exp) {
+	return terms.Sum(q.W), terms.Sum(q.X), terms.Sum(q.Y), terms.Sum(q.Z)
+}
+
+// Mul returns the Hamilton product, q*r.
+func (q Quat) Mul(r Quat) Quat {
+	w1, x1, y1, z1 := q.parts()
+	w2, x2, y2, z2 := r.parts()
+	return Quat{
+		W: terms.Mul(w1, w2).Sub(terms.Mul(x1, x2)).Sub(terms.Mul(y1, y2)).Sub(terms.Mul(z1, z2)),
+		X: terms.Sum(terms.Mul(w1, x2), terms.Mul(x1, w2), terms.Mul(y1, z2)).Sub(terms.Mul(z1, y2)),
+		Y: terms.Sum(terms.Mul(w1, y2), terms.Mul(y1, w2), terms.Mul(z1, x2)).Sub(terms.Mul(x1, z2)),
+		Z: terms.Sum(terms.Mul(w1, z2), terms.Mul(z1, w2), terms.Mul(x1, y2)).Sub(terms.Mul(y1, x2)),
+	}
+}
+
+// ToMatrix returns the 3x3 rotation matrix equivalent to the unit
+// quaternion q.
+func (q Quat) ToMatrix() *matrix.Matrix {
+	w, x, y, z := q.parts()
+	two := terms.NewExp([]factor.Value{factor.D(2, 1)})
+	// twice returns 2*(a*b+s*c*d) for s=+1 or -1.
+	twice := func(a, b *terms.Exp, s int, c, d *terms.Exp) *terms.Exp {
+		cd := terms.Mul(c, d)
+		if s < 0 {
+			return terms.Mul(two, terms.Mul(a, b).Sub(cd))
+		}
+		return terms.Mul(two, terms.Mul(a, b).Add(cd))
+	}
+	// diag returns 1-2*(a^2+b^2).
+	diag := func(a, b *terms.Exp) *terms.Exp {
+		return one.Sub(twice(a, a, 1, b, b))
+	}
+	m, _ := matrix.NewMatrix(3, 3)
+	m.Set(0, 0, diag(y, z))
+	m.Set(0, 1, twice(x, y, -1, z, w))
+	m.Set(0, 2, twice(x, z, 1, y, w))
+	m.Set(1, 0, twice(x, y, 1, z, w))
+	m.Set(1, 1, diag(x, z))
+	m.Set(1, 2, twice(y, z, -1, x, w))
+	m.Set(2, 0, twice(x, z, -1, y, w))
+	m.Set(2, 1, twice(y, z, 1, x, w))
+	m.Set(2, 2, diag(x, y))
+	return m
+}
+
+// EvalAngles computes the floating point values of the elements of
+// m. The bindings hold numerical values for angles, keyed by angle
+// name, and the s, c and t prefixed symbols of each angle are
+// evaluated with math.Sin, math.Cos and math.Tan. A binding also
+// provides the value of the unprefixed symbol, so lengths can be
+// bound too. An error is returned for any unbound symbol.
+func EvalAngles(m *matrix.Matrix, bindings map[string]float64) ([][]float64, error) {
+	b := make(map[string]float64)
+	for name, v := range bindings {
+		b["s"+name] = math.Sin(v)
+		b["c"+name] = math.Cos(v)
+		b["t"+name] = math.Tan(v)
+	}
+	for name, v := range bindings {
+		b[name] = v
+	}
+	rows, cols := m.Dims()
+	vs := make([][]float64, rows)
+	for i := range vs {
+		vs[i] = make([]float64, cols)
+		for j := range vs[i] {
+			x, err := m.Frac(i, j).EvalFloat(b)
+			if err != nil {
+				return nil, fmt.Errorf("element [%d,%d]: %v", i, j, err)
+			}
+			vs[i][j] = x
+		}
+	}
+	return vs, nil
+}
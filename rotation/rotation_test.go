@@ -1,6 +1,8 @@
 package rotation
 
 import (
+	"fmt"
+	"math"
 	"testing"
 
 	"zappem.net/pub/math/algex/factor"
@@ -32,3 +34,264 @@ func TestR(t *testing.T) {
 		}
 	}
 }
+
+func TestHomogeneous(t *testing.T) {
+	tr, err := Translate("x", "y", "0")
+	if err != nil {
+		t.Fatalf("failed to build translation: %v", err)
+	}
+	if got, want := tr.String(), "[[1, 0, 0, x], [0, 1, 0, y], [0, 0, 1, 0], [0, 0, 0, 1]]"; got != want {
+		t.Errorf("translate: got=%q, want=%q", got, want)
+	}
+	if _, err := Translate("x", "y+", "0"); err == nil {
+		t.Error("translate by bad offset succeeded")
+	}
+	h, err := Homogeneous(RZ("t"), "x", "y", "z")
+	if err != nil {
+		t.Fatalf("failed to build homogeneous matrix: %v", err)
+	}
+	if got, want := h.String(), "[[ct, -st, 0, x], [st, ct, 0, y], [0, 0, 1, z], [0, 0, 0, 1]]"; got != want {
+		t.Errorf("homogeneous: got=%q, want=%q", got, want)
+	}
+	// Rotating then translating by d is the same as translating
+	// by R*d and then rotating.
+	td, _ := Translate("d", "0", "0")
+	trd, _ := Translate("ct*d", "-st*d", "0")
+	a := td.Mx(h)
+	b := h.Mx(trd)
+	ab := a.Add(b, terms.NewExp([]factor.Value{factor.D(-1, 1)})).Substitute(
+		[]factor.Value{factor.Sp("ct", 2)},
+		terms.NewExp([]factor.Value{factor.D(1, 1)}, []factor.Value{factor.D(-1, 1), factor.Sp("st", 2)}),
+	)
+	if got, want := ab.String(), "[[0, 0, 0, 0], [0, 0, 0, 0], [0, 0, 0, 0], [0, 0, 0, 0]]"; got != want {
+		t.Errorf("translation composition: got=%q, want=%q", got, want)
+	}
+	if _, err := Homogeneous(h, "x", "y", "z"); err == nil {
+		t.Error("accepted a 4x4 rotation")
+	}
+	if _, err := Homogeneous(RX("t"), "x", "y+", "z"); err == nil {
+		t.Error("accepted a bad offset")
+	}
+}
+
+func TestUnitSigns(t *testing.T) {
+	h, err := Homogeneous(RX("a"), "1", "-1", "0")
+	if err != nil {
+		t.Fatalf("failed to build homogeneous matrix: %v", err)
+	}
+	if got, want := h.Mx(h).String(), "[[1, 0, 0, 2], [0, ca^2-sa^2, -2*ca*sa, -1-ca], [0, 2*ca*sa, ca^2-sa^2, -sa], [0, 0, 0, 1]]"; got != want {
+		t.Errorf("composed: got=%q, want=%q", got, want)
+	}
+	if got, want := RX("a").Mx(RX("b")).Add(RX("a"), one).String(), "[[2, 0, 0], [0, ca+ca*cb-sa*sb, -ca*sb-cb*sa-sa], [0, ca*sb+cb*sa+sa, ca+ca*cb-sa*sb]]"; got != want {
+		t.Errorf("sum: got=%q, want=%q", got, want)
+	}
+}
+
+func TestApplyPoint(t *testing.T) {
+	h, err := Homogeneous(RZ("t"), "d", "0", "0")
+	if err != nil {
+		t.Fatalf("failed to build homogeneous matrix: %v", err)
+	}
+	p, err := ApplyPoint(h, "x", "0", "z")
+	if err != nil {
+		t.Fatalf("failed to apply matrix: %v", err)
+	}
+	if got, want := p.String(), "[[ct*x+d], [st*x], [z]]"; got != want {
+		t.Errorf("transformed point: got=%q, want=%q", got, want)
+	}
+
+	// A perspective projection onto the plane z=f.
+	proj, _ := matrix.Identity(4)
+	zf, _ := terms.ParseExp("f^-1")
+	proj.Set(3, 2, zf)
+	proj.Set(3, 3, nil)
+	p, err = ApplyPoint(proj, "x", "y", "z")
+	if err != nil {
+		t.Fatalf("failed to project: %v", err)
+	}
+	if got, want := p.String(), "[[f*x*z^-1], [f*y*z^-1], [f]]"; got != want {
+		t.Errorf("projected point: got=%q, want=%q", got, want)
+	}
+
+	if _, err := ApplyPoint(RZ("t"), "x", "y", "z"); err == nil {
+		t.Error("accepted a 3x3 matrix")
+	}
+	if _, err := ApplyPoint(h, "x", "y+", "z"); err == nil {
+		t.Error("accepted a bad point")
+	}
+	if _, err := ApplyPoint(proj, "x", "y", "0"); err == nil {
+		t.Error("accepted a point at infinity")
+	}
+}
+
+func TestTrace(t *testing.T) {
+	e, err := RZ("t").Trace()
+	if err != nil {
+		t.Fatalf("failed to trace: %v", err)
+	}
+	if got, want := e.String(), "1+2*ct"; got != want {
+		t.Errorf("trace: got=%q, want=%q", got, want)
+	}
+}
+
+func TestOrthogonal(t *testing.T) {
+	r := RZ("t")
+	cols := make([]*matrix.Matrix, 3)
+	for j := range cols {
+		cols[j], _ = matrix.NewMatrix(3, 1)
+		for i := 0; i < 3; i++ {
+			cols[j].Set(i, 0, r.El(i, j))
+		}
+	}
+	for i := 0; i < 3; i++ {
+		for j := i + 1; j < 3; j++ {
+			e, err := matrix.Dot(cols[i], cols[j])
+			if err != nil {
+				t.Fatalf("dot product failed: %v", err)
+			}
+			if got := e.ApplyPythagorean("t").String(); got != "0" {
+				t.Errorf("col[%d].col[%d]: got=%q, want=\"0\"", i, j, got)
+			}
+		}
+	}
+	rrt := r.Mx(r.Transpose()).Map(func(_, _ int, e *terms.Exp) *terms.Exp {
+		return e.ApplyPythagorean("t")
+	})
+	if !rrt.IsIdentity() {
+		t.Errorf("R*R^T: got=%v, want identity", rrt)
+	}
+}
+
+func TestNormSquared(t *testing.T) {
+	r := RX("t")
+	for j := 0; j < 3; j++ {
+		col, _ := matrix.NewMatrix(3, 1)
+		for i := 0; i < 3; i++ {
+			col.Set(i, 0, r.El(i, j))
+		}
+		e, err := col.NormSquared()
+		if err != nil {
+			t.Fatalf("norm failed: %v", err)
+		}
+		if got := e.ApplyPythagorean("t").String(); got != "1" {
+			t.Errorf("|col[%d]|^2: got=%q, want=\"1\"", j, got)
+		}
+	}
+}
+
+func TestMap(t *testing.T) {
+	r := RY("t")
+	n := r.Transpose().Mx(r).Map(func(_, _ int, e *terms.Exp) *terms.Exp {
+		if e == nil {
+			return nil
+		}
+		return e.ApplyPythagorean("t")
+	})
+	if got, want := n.String(), "[[1, 0, 0], [0, 1, 0], [0, 0, 1]]"; got != want {
+		t.Errorf("got=%q, want=%q", got, want)
+	}
+}
+
+func TestEuler(t *testing.T) {
+	m, err := Euler("ZYX", "a", "b", "c")
+	if err != nil {
+		t.Fatalf("euler failed: %v", err)
+	}
+	if got, want := m.String(), RZ("a").Mx(RY("b")).Mx(RX("c")).String(); got != want {
+		t.Errorf("ZYX: got=%q, want=%q", got, want)
+	}
+	if m, err = Euler("ZXZ", "a", "b", "c"); err != nil {
+		t.Fatalf("euler failed: %v", err)
+	}
+	d, err := m.Determinant()
+	if err != nil {
+		t.Fatalf("determinant failed: %v", err)
+	}
+	for _, angle := range []string{"a", "b", "c"} {
+		d = d.ApplyPythagorean(angle)
+	}
+	if got := d.String(); got != "1" {
+		t.Errorf("ZXZ determinant: got=%q, want=\"1\"", got)
+	}
+	for _, order := range []string{"", "ZY", "ZYXZ", "ZWX", "zyx"} {
+		if _, err := Euler(order, "a", "b", "c"); err == nil {
+			t.Errorf("accepted order %q", order)
+		}
+	}
+}
+
+func TestQuat(t *testing.T) {
+	e := func(s string) *terms.Exp {
+		x, err := terms.ParseExp(s)
+		if err != nil {
+			t.Fatalf("bad expression %q: %v", s, err)
+		}
+		return x
+	}
+	i, j := Quat{X: one}, Quat{Y: one}
+	k := i.Mul(j)
+	if got, want := fmt.Sprint(k.W, k.X, k.Y, k.Z), "0 0 0 1"; got != want {
+		t.Errorf("i*j: got=%q, want=%q", got, want)
+	}
+	k = j.Mul(i)
+	if got, want := fmt.Sprint(k.W, k.X, k.Y, k.Z), "0 0 0 -1"; got != want {
+		t.Errorf("j*i: got=%q, want=%q", got, want)
+	}
+
+	q := Quat{W: e("w"), X: e("x"), Y: e("y"), Z: e("z")}
+	d, err := q.ToMatrix().Determinant()
+	if err != nil {
+		t.Fatalf("determinant failed: %v", err)
+	}
+	// A unit quaternion has w^2 = 1-x^2-y^2-z^2.
+	d = d.Substitute([]factor.Value{factor.Sp("w", 2)}, e("1-x^2-y^2-z^2"))
+	if got := d.String(); got != "1" {
+		t.Errorf("determinant: got=%q, want=\"1\"", got)
+	}
+
+	// A rotation by angle 2*h around the Z-axis.
+	m := Quat{W: e("ch"), Z: e("sh")}.ToMatrix()
+	if got, want := m.String(), "[[1-2*sh^2, -2*ch*sh, 0], [2*ch*sh, 1-2*sh^2, 0], [0, 0, 1]]"; got != want {
+		t.Errorf("rotation: got=%q, want=%q", got, want)
+	}
+}
+
+func TestEvalAngles(t *testing.T) {
+	m, err := Homogeneous(RZ("t"), "d0", "0", "0")
+	if err != nil {
+		t.Fatalf("homogeneous failed: %v", err)
+	}
+	vs, err := EvalAngles(m, map[string]float64{"t": math.Pi / 2, "d0": 2})
+	if err != nil {
+		t.Fatalf("evaluation failed: %v", err)
+	}
+	want := [][]float64{
+		{0, -1, 0, 2},
+		{1, 0, 0, 0},
+		{0, 0, 1, 0},
+		{0, 0, 0, 1},
+	}
+	for i := range want {
+		for j := range want[i] {
+			if math.Abs(vs[i][j]-want[i][j]) > 1e-12 {
+				t.Errorf("[%d,%d] got=%g want=%g", i, j, vs[i][j], want[i][j])
+			}
+		}
+	}
+	tan, _ := matrix.NewMatrix(1, 2)
+	tan.SetFrac(0, 0, &terms.Frac{Num: RX("a").El(2, 1), Den: RX("a").El(1, 1)})
+	ta, _ := terms.ParseExp("ta")
+	tan.Set(0, 1, ta)
+	vs, err = EvalAngles(tan, map[string]float64{"a": 0.3})
+	if err != nil {
+		t.Fatalf("evaluating tangents failed: %v", err)
+	}
+	for j, got := range vs[0] {
+		if math.Abs(got-math.Tan(0.3)) > 1e-12 {
+			t.Errorf("[%d] tangent: got=%g want=%g", j, got, math.Tan(0.3))
+		}
+	}
+	if _, err := EvalAngles(m, map[string]float64{"t": 1}); err == nil {
+		t.Error("unbound d0 should fail")
+	}
+}
@@ -2,10 +2,16 @@
 package terms
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"math"
 	"math/big"
+	"math/rand"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 
 	"zappem.net/pub/math/algex/factor"
@@ -51,12 +57,34 @@ func (e *Exp) String() string {
 	for x := range e.terms {
 		s = append(s, x)
 	}
-	// TODO: might want to prefer a non-ascii sorted expression.
+	// See StringOrdered for a non-ascii sorted expression.
 	sort.Strings(s)
+	return e.join(s, factor.FormatOptions{})
+}
+
+// StringWith represents an expression as a string in the same term
+// order as String, but with its products and powers rendered
+// according to opts. It is intended for documentation-ready output;
+// the result is not necessarily accepted by ParseExp.
+func (e *Exp) StringWith(opts factor.FormatOptions) string {
+	if e.IsZero() {
+		return "0"
+	}
+	var s []string
+	for x := range e.terms {
+		s = append(s, x)
+	}
+	sort.Strings(s)
+	return e.join(s, opts)
+}
+
+// join concatenates the terms of e indexed by the keys, s, in order,
+// rendering each with opts.
+func (e *Exp) join(s []string, opts factor.FormatOptions) string {
 	for i, x := range s {
 		f := e.terms[x]
 		v := []factor.Value{factor.R(f.Coeff)}
-		t := factor.Prod(append(v, f.Fact...)...)
+		t := opts.Prod(append(v, f.Fact...)...)
 		if i != 0 && t[0] != '-' {
 			s[i] = "+" + t
 		} else {
@@ -66,6 +94,97 @@ func (e *Exp) String() string {
 	return strings.Join(s, "")
 }
 
+// StringOrdered represents an expression as a string with its terms
+// in descending graded lexical order: highest total power first,
+// and terms of equal total power ordered by their symbols.
+func (e *Exp) StringOrdered() string {
+	if e.IsZero() {
+		return "0"
+	}
+	var s []string
+	for x := range e.terms {
+		s = append(s, x)
+	}
+	sort.Slice(s, func(i, j int) bool {
+		return grlex(e.terms[s[i]].Fact, e.terms[s[j]].Fact) > 0
+	})
+	return e.join(s, factor.FormatOptions{})
+}
+
+// latexTerm renders a single term as LaTeX, without its sign. The
+// factors with negative powers are collected into a denominator.
+// Function tokens are rendered with their definitions from fns.
+func latexTerm(t Term, fns map[string]FnDef) string {
+	var num, den []string
+	n := new(big.Int).Abs(t.Coeff.Num())
+	if n.Cmp(big.NewInt(1)) != 0 {
+		num = append(num, n.String())
+	}
+	if d := t.Coeff.Denom(); d.Cmp(big.NewInt(1)) != 0 {
+		den = append(den, d.String())
+	}
+	for _, v := range t.Fact {
+		sym := v.Symbol()
+		if fn, ok := fns[sym]; ok {
+			var args []string
+			for _, a := range fn.Args {
+				args = append(args, a.LaTeX())
+			}
+			sym = fmt.Sprintf("\\%s(%s)", fn.Name, strings.Join(args, ","))
+		}
+		p := v.Pow()
+		if p < 0 {
+			p = -p
+		}
+		if r := v.Root(); r != 1 {
+			sym = fmt.Sprintf("%s^{\\frac{%d}{%d}}", sym, p, r)
+		} else if p != 1 {
+			sym = fmt.Sprintf("%s^{%d}", sym, p)
+		}
+		if v.Pow() < 0 {
+			den = append(den, sym)
+		} else {
+			num = append(num, sym)
+		}
+	}
+	if len(num) == 0 {
+		num = append(num, "1")
+	}
+	if len(den) == 0 {
+		return strings.Join(num, " ")
+	}
+	return fmt.Sprintf("\\frac{%s}{%s}", strings.Join(num, " "), strings.Join(den, " "))
+}
+
+// latex renders e as LaTeX, in the same term order as String.
+func (e *Exp) latex(fns map[string]FnDef) string {
+	if e.IsZero() {
+		return "0"
+	}
+	var keys []string
+	for x := range e.terms {
+		keys = append(keys, x)
+	}
+	sort.Strings(keys)
+	var s []string
+	for i, x := range keys {
+		t := e.terms[x]
+		if t.Coeff.Sign() < 0 {
+			s = append(s, "-")
+		} else if i != 0 {
+			s = append(s, "+")
+		}
+		s = append(s, latexTerm(t, fns))
+	}
+	return strings.Join(s, "")
+}
+
+// LaTeX renders an expression in LaTeX notation. Products are
+// juxtaposed and negative powers are rendered as fractions.
+func (e *Exp) LaTeX() string {
+	return e.latex(nil)
+}
+
 // Int generates an expression of a constant integer.
 func Int(n *big.Int) *Exp {
 	return NewExp([]factor.Value{factor.I(n)})
@@ -96,6 +215,15 @@ func (e *Exp) insert(n *big.Rat, fs []factor.Value, s string) {
 	e.terms[s] = old
 }
 
+// factors returns a new slice holding the coefficient and then the
+// factors of term. The slice shares no storage with term, so it can
+// be appended to without affecting term.
+func (term Term) factors() []factor.Value {
+	fs := make([]factor.Value, 0, len(term.Fact)+1)
+	fs = append(fs, factor.R(term.Coeff))
+	return append(fs, term.Fact...)
+}
+
 // Exp converts a Term into a stand alone expression.
 func (term Term) Exp() *Exp {
 	e := &Exp{
@@ -181,22 +309,64 @@ func (e *Exp) Mod(x factor.Value) *Exp {
 	return a
 }
 
+// ReduceModP reduces every coefficient of e modulo p, which should
+// be a prime, into the range [0,p). A rational coefficient, n/d, is
+// reduced to n times the modular inverse of d. Terms whose
+// coefficients reduce to 0 are dropped. Unlike Mod, this gives the
+// expression with coefficients in the integers modulo p, GF(p) for
+// prime p. An error is returned if a denominator has no inverse
+// modulo p.
+func (e *Exp) ReduceModP(p int64) (*Exp, error) {
+	if p < 2 {
+		return nil, fmt.Errorf("invalid modulus %d", p)
+	}
+	m := big.NewInt(p)
+	a := &Exp{terms: make(map[string]Term)}
+	if e == nil {
+		return a, nil
+	}
+	for s, v := range e.terms {
+		inv := new(big.Int).ModInverse(v.Coeff.Denom(), m)
+		if inv == nil {
+			return nil, fmt.Errorf("denominator of %s is not invertible modulo %d", v.Coeff.RatString(), p)
+		}
+		n := new(big.Int).Mul(v.Coeff.Num(), inv)
+		if n.Mod(n, m).Sign() == 0 {
+			continue
+		}
+		a.terms[s] = Term{
+			Coeff: new(big.Rat).SetInt(n),
+			Fact:  v.Fact,
+		}
+	}
+	return a, nil
+}
+
+// unit is a numerical factor of 1. It is only ever read.
+var unit = factor.D(1, 1)
+
 // Mul computes the product of a series of expressions.
 func Mul(as ...*Exp) *Exp {
 	var e *Exp
+	var buf []factor.Value
 	for i, a := range as {
 		if i == 0 {
 			e = Sum(a)
 			continue
 		}
 		f := &Exp{
-			terms: make(map[string]Term),
+			terms: make(map[string]Term, len(a.terms)*len(e.terms)),
 		}
 		for _, p := range a.terms {
 			for _, q := range e.terms {
-				x := []factor.Value{factor.R(p.Coeff), factor.R(q.Coeff)}
-				n, fs, s := factor.Segment(append(x, append(p.Fact, q.Fact...)...)...)
-				f.insert(n, fs, s)
+				// The unit factor ensures Segment returns a
+				// coefficient even when there are no symbols.
+				buf = append(buf[:0], unit)
+				buf = append(buf, p.Fact...)
+				buf = append(buf, q.Fact...)
+				n, fs, s := factor.Segment(buf...)
+				n.Mul(n, p.Coeff)
+				f.insert(n.Mul(n, q.Coeff), fs, s)
 			}
 		}
 		e = f
@@ -213,22 +383,32 @@ func (e *Exp) Mul(es ...*Exp) *Exp {
 // expression c. If the returned boolean is true, then something was
 // substituted.
 func (e *Exp) Substituted(b []factor.Value, c *Exp) (*Exp, bool) {
+	g, acted, _ := e.substituted(context.Background(), b, c)
+	return g, acted
+}
+
+// substituted implements Substituted, checking ctx before each pass
+// over the terms of the expression.
+func (e *Exp) substituted(ctx context.Context, b []factor.Value, c *Exp) (*Exp, bool, error) {
 	if len(b) == 0 {
-		return e, false
+		return e, false, nil
 	}
 	s := [][]factor.Value{}
 	for _, t := range c.terms {
-		s = append(s, append([]factor.Value{factor.R(t.Coeff)}, t.Fact...))
+		s = append(s, t.factors())
 	}
 	g := e
 	acted := false
 	for {
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
 		again := false
 		f := &Exp{
 			terms: make(map[string]Term),
 		}
 		for _, x := range g.terms {
-			a := append([]factor.Value{factor.R(x.Coeff)}, x.Fact...)
+			a := x.factors()
 			hit, y := factor.Replace(a, b, zero, 1)
 			if hit == 0 {
 				n, fs, tag := factor.Segment(y...)
@@ -253,7 +433,7 @@ func (e *Exp) Substituted(b []factor.Value, c *Exp) (*Exp, bool) {
 		}
 		acted = true
 	}
-	return g, acted
+	return g, acted, nil
 }
 
 // Substitute unconditionally attempts to substitute occurences of b
@@ -264,10 +444,50 @@ func (e *Exp) Substitute(b []factor.Value, c *Exp) *Exp {
 	return e2
 }
 
+// Rule is a substitution used by Reduce: the product of factors, From,
+// is replaced by the expression, To.
+type Rule struct {
+	From []factor.Value
+	To   *Exp
+}
+
+// reducePasses bounds the number of times Reduce applies its rules.
+const reducePasses = 8
+
+// Reduce repeatedly substitutes the rules into e until none of them
+// change it. In each pass, the rules are applied in a fixed order:
+// those with the largest From order (see factor.Order) first, ties
+// being broken by the name of the From product. Reduce returns the
+// reduced expression and whether it reached a fixed point within a
+// bounded number of passes. Rules that undo each other never reach a
+// fixed point.
+func Reduce(e *Exp, rules []Rule) (*Exp, bool) {
+	rs := append([]Rule(nil), rules...)
+	sort.SliceStable(rs, func(i, j int) bool {
+		a, b := factor.Order(rs[i].From), factor.Order(rs[j].From)
+		if a != b {
+			return a > b
+		}
+		return factor.Prod(rs[i].From...) < factor.Prod(rs[j].From...)
+	})
+	for i := 0; i < reducePasses; i++ {
+		changed := false
+		for _, r := range rs {
+			var modified bool
+			e, modified = e.Substituted(r.From, r.To)
+			changed = changed || modified
+		}
+		if !changed {
+			return e, true
+		}
+	}
+	return e, false
+}
+
 // Contains investigates an expression for the presence of a term, b.
 func (e *Exp) Contains(b []factor.Value) bool {
 	for _, x := range e.terms {
-		a := append([]factor.Value{factor.R(x.Coeff)}, x.Fact...)
+		a := x.factors()
 		if hit, _ := factor.Replace(a, b, zero, 1); hit != 0 {
 			return true
 		}
@@ -286,7 +506,7 @@ func (e *Exp) Partition(b []factor.Value) (div, rem *Exp) {
 	d := NewExp()
 	r := NewExp()
 	for _, x := range e.terms {
-		a := append([]factor.Value{factor.R(x.Coeff)}, x.Fact...)
+		a := x.factors()
 		if hit, fac := factor.Replace(a, b, one, 1); hit != 0 {
 			d = d.Add(NewExp(fac))
 		} else {
@@ -302,6 +522,36 @@ func (e *Exp) Partition(b []factor.Value) (div, rem *Exp) {
 	return
 }
 
+// Match is a variant of Partition. It returns the sum of the terms
+// of e containing pattern, divided by one power of pattern, as coeff,
+// and the remaining terms as rest. Neither is nil. The found value
+// is true if any term of e contains pattern.
+func (e *Exp) Match(pattern []factor.Value) (coeff *Exp, rest *Exp, found bool) {
+	div, rem := e.Partition(pattern)
+	return Sum(div), Sum(rem), div != nil
+}
+
+// SplitBy partitions the terms of e into two expressions. The
+// varPart holds all of the terms that contain some symbol for which
+// isVar returns true. The constPart holds the remaining terms.
+func (e *Exp) SplitBy(isVar func(sym string) bool) (varPart, constPart *Exp) {
+	varPart, constPart = NewExp(), NewExp()
+	if e == nil {
+		return
+	}
+	for s, t := range e.terms {
+		part := constPart
+		for _, v := range t.Fact {
+			if isVar(v.Symbol()) {
+				part = varPart
+				break
+			}
+		}
+		part.insert(new(big.Rat).Set(t.Coeff), t.Fact, s)
+	}
+	return
+}
+
 // AsNumber ignores all terms that contain symbols, and just returns
 // the value of the constant term. The returned boolean is true only
 // when there are no non-constant terms.
@@ -317,6 +567,20 @@ func (e *Exp) AsNumber() (*big.Rat, bool) {
 	return zero[0].Num(), ok
 }
 
+// Sign returns -1, 0 or 1 for a negative, zero or positive constant
+// expression. The returned boolean is false if e is not a constant,
+// in which case the sign is 0.
+func (e *Exp) Sign() (int, bool) {
+	if e.IsZero() {
+		return 0, true
+	}
+	n, ok := e.AsNumber()
+	if !ok {
+		return 0, false
+	}
+	return n.Sign(), true
+}
+
 // Terms returns the prevailing coefficient and array of unsorted
 // simplified terms associated with an expression.
 func (e *Exp) Terms() map[string]Term {
@@ -326,6 +590,345 @@ func (e *Exp) Terms() map[string]Term {
 	return e.terms
 }
 
+// Len returns the number of terms in e.
+func (e *Exp) Len() int {
+	if e == nil {
+		return 0
+	}
+	return len(e.terms)
+}
+
+// SortedTerms returns a copy of each term of e. The terms are in
+// the same descending graded lexical order as StringOrdered. The
+// copies can be modified without affecting e.
+func (e *Exp) SortedTerms() []Term {
+	var ts []Term
+	if e == nil {
+		return ts
+	}
+	for _, t := range e.sortedTerms() {
+		ts = append(ts, Term{
+			Coeff: new(big.Rat).Set(t.Coeff),
+			Fact:  append([]factor.Value(nil), t.Fact...),
+		})
+	}
+	sort.SliceStable(ts, func(i, j int) bool {
+		return grlex(ts[i].Fact, ts[j].Fact) > 0
+	})
+	return ts
+}
+
+// Coefficient returns the coefficient of the monomial fact in e, or
+// zero if e has no such term. Any numerical factor of fact divides
+// the returned coefficient.
+func (e *Exp) Coefficient(fact []factor.Value) *big.Rat {
+	n, _, key := factor.Segment(append([]factor.Value{factor.D(1, 1)}, fact...)...)
+	t, ok := e.Terms()[key]
+	if n == nil || !ok {
+		return new(big.Rat)
+	}
+	return new(big.Rat).Quo(t.Coeff, n)
+}
+
+// FloatCoeffs returns the coefficients of e, a polynomial in the
+// symbol sym, as floating point numbers. The coefficient of sym^p is
+// held at index p-min, where min is the returned lowest power of sym
+// in e, or 0 if that is positive. So, for a polynomial with no
+// negative powers, index 0 holds the constant term. An error is
+// returned if e contains other symbols or non-integer powers of sym.
+// The conversion from big.Rat to float64 rounds each coefficient to
+// the nearest float64, so precision is lost for coefficients with
+// large numerators or denominators, and very large or small ones
+// become infinite or zero.
+func (e *Exp) FloatCoeffs(sym factor.Value) ([]float64, int, error) {
+	x := factor.S(sym.Symbol())
+	min, max := 0, 0
+	cs := make(map[int]*big.Rat)
+	for p, c := range e.Collect(x) {
+		n, ok := c.AsNumber()
+		if !ok {
+			return nil, 0, fmt.Errorf("%v is not a polynomial in %v alone", e, x)
+		}
+		cs[p] = n
+		if p < min {
+			min = p
+		}
+		if p > max {
+			max = p
+		}
+	}
+	fs := make([]float64, max-min+1)
+	for p, n := range cs {
+		fs[p-min], _ = n.Float64()
+	}
+	return fs, min, nil
+}
+
+// Iterate returns a copy of each term of e, in the same order as
+// String, with its coefficient and non-numerical factors
+// separated. The copies can be modified without affecting e.
+func (e *Exp) Iterate() []struct {
+	Coeff   *big.Rat
+	Factors []factor.Value
+} {
+	var ts []struct {
+		Coeff   *big.Rat
+		Factors []factor.Value
+	}
+	if e == nil {
+		return ts
+	}
+	for _, t := range e.sortedTerms() {
+		ts = append(ts, struct {
+			Coeff   *big.Rat
+			Factors []factor.Value
+		}{
+			Coeff:   new(big.Rat).Set(t.Coeff),
+			Factors: append([]factor.Value(nil), t.Fact...),
+		})
+	}
+	return ts
+}
+
+// Collect groups the terms of e by their power of the symbol sym.
+// The returned map is indexed by power, and each entry holds the
+// coefficient expression (free of sym) for that power.
+func (e *Exp) Collect(sym factor.Value) map[int]*Exp {
+	cs := make(map[int]*Exp)
+	if e == nil {
+		return cs
+	}
+	name := sym.Symbol()
+	for _, t := range e.terms {
+		p := 0
+		var fs []factor.Value
+		for _, v := range t.Fact {
+			if v.Symbol() == name && v.Root() == 1 {
+				p = v.Pow()
+				continue
+			}
+			fs = append(fs, v)
+		}
+		c, ok := cs[p]
+		if !ok {
+			c = NewExp()
+			cs[p] = c
+		}
+		c.insert(new(big.Rat).Set(t.Coeff), fs, factor.Prod(fs...))
+	}
+	return cs
+}
+
+// Degree returns the highest power of sym found in e. An expression
+// that does not contain sym has a degree of 0. If only negative
+// powers of sym are present, the degree is negative.
+func (e *Exp) Degree(sym factor.Value) int {
+	n, first := 0, true
+	for p := range e.Collect(sym) {
+		if first || p > n {
+			n = p
+			first = false
+		}
+	}
+	return n
+}
+
+// PowersOf returns the sorted distinct powers of sym found in the
+// terms of e. Terms that do not contain sym contribute a power of 0.
+func (e *Exp) PowersOf(sym factor.Value) []int {
+	var ps []int
+	for p := range e.Collect(sym) {
+		ps = append(ps, p)
+	}
+	sort.Ints(ps)
+	return ps
+}
+
+// ReducePowers attempts to re-express e in terms of a new symbol,
+// newSym = sym^k, where k > 1 is the largest integer that divides all
+// of the powers of sym in e. On success, the rewritten expression and
+// k are returned. It fails if sym is absent from e or newSym is
+// already present.
+func (e *Exp) ReducePowers(sym factor.Value, newSym string) (*Exp, int, bool) {
+	for _, s := range e.Symbols() {
+		if s.Symbol() == newSym {
+			return nil, 0, false
+		}
+	}
+	g := big.NewInt(0)
+	cs := e.Collect(sym)
+	for p := range cs {
+		g = gcd(g, big.NewInt(int64(p)))
+	}
+	k := int(g.Int64())
+	if k < 2 {
+		return nil, 0, false
+	}
+	var es []*Exp
+	for p, c := range cs {
+		es = append(es, Mul(c, NewExp([]factor.Value{factor.Sp(newSym, p/k)})))
+	}
+	return Sum(es...), k, true
+}
+
+// parts splits e into its real and imaginary parts. Powers of the
+// imaginary unit, factor.ImaginaryUnit, are reduced using i^2=-1 and
+// all other symbols are treated as real.
+func (e *Exp) parts() (re, im *Exp) {
+	re, im = NewExp(), NewExp()
+	for p, c := range e.Collect(factor.S(factor.ImaginaryUnit)) {
+		switch (p%4 + 4) % 4 {
+		case 0:
+			re = re.Add(c)
+		case 1:
+			im = im.Add(c)
+		case 2:
+			re = re.Sub(c)
+		case 3:
+			im = im.Sub(c)
+		}
+	}
+	return
+}
+
+// Real returns the real part of e.
+func (e *Exp) Real() *Exp {
+	re, _ := e.parts()
+	return re
+}
+
+// Imag returns the imaginary part of e. That is, the real
+// coefficient of the imaginary unit.
+func (e *Exp) Imag() *Exp {
+	_, im := e.parts()
+	return im
+}
+
+// IsReal confirms that e has no imaginary part.
+func (e *Exp) IsReal() bool {
+	return e.Imag().IsZero()
+}
+
+// Conjugate returns the complex conjugate of e.
+func (e *Exp) Conjugate() *Exp {
+	re, im := e.parts()
+	return re.Sub(Mul(im, NewExp([]factor.Value{factor.S(factor.ImaginaryUnit)})))
+}
+
+// LeadingCoeff returns the coefficient expression of the highest
+// power of sym found in e. If e does not contain sym, this is all of
+// e.
+func (e *Exp) LeadingCoeff(sym factor.Value) *Exp {
+	if c, ok := e.Collect(sym)[e.Degree(sym)]; ok {
+		return c
+	}
+	return NewExp()
+}
+
+// ApplyPythagorean simplifies e with the identity s<angle>^2 +
+// c<angle>^2 = 1, where s<angle> and c<angle> are the sine and cosine
+// of angle, following the naming convention of the rotation package.
+// Pairs of terms, k*s<angle>^2*X and k*c<angle>^2*X, are replaced with
+// k*X. When the coefficients of such a pair differ, but have the same
+// sign, the common part of the coefficient is replaced. Terms without
+// a partner are left untouched.
+func (e *Exp) ApplyPythagorean(angle string) *Exp {
+	s, c := "s"+angle, "c"+angle
+	g := Sum(e)
+	for again := true; again; {
+		again = false
+		for key, t := range g.terms {
+			var rest []factor.Value
+			found := false
+			for _, v := range t.Fact {
+				if v.Symbol() == s && v.Pow() >= 2 && !found {
+					found = true
+					v = factor.Sp(s, v.Pow()-2)
+				}
+				rest = append(rest, v)
+			}
+			if !found {
+				continue
+			}
+			pair := append([]factor.Value{factor.Sp(c, 2)}, rest...)
+			_, _, pkey := factor.Segment(pair...)
+			u, ok := g.terms[pkey]
+			if !ok || u.Coeff.Sign() != t.Coeff.Sign() {
+				continue
+			}
+			k := new(big.Rat).Set(t.Coeff)
+			if new(big.Rat).Abs(u.Coeff).Cmp(new(big.Rat).Abs(k)) < 0 {
+				k.Set(u.Coeff)
+			}
+			negK := new(big.Rat).Neg(k)
+			g.insert(new(big.Rat).Set(negK), t.Fact, key)
+			g.insert(new(big.Rat).Set(negK), u.Fact, pkey)
+			n, fs, rkey := factor.Segment(append([]factor.Value{factor.R(k)}, rest...)...)
+			g.insert(n, fs, rkey)
+			again = true
+			break
+		}
+	}
+	return g
+}
+
+// WeierstrassSubstitute applies the tangent half-angle substitution
+// to e for the named angle. Following the s<angle>, c<angle>
+// convention, it rewrites s<angle> as 2*t<angle>/(1+t<angle>^2) and
+// c<angle> as (1-t<angle>^2)/(1+t<angle>^2), where t<angle> is the
+// tangent of half the angle. The result is a reduced rational function
+// in t<angle>, so trigonometric equations become polynomial ones.
+func (e *Exp) WeierstrassSubstitute(angle string) *Frac {
+	s, c, t := "s"+angle, "c"+angle, factor.S("t"+angle)
+	den := NewExp(one, []factor.Value{factor.Sp(t.Symbol(), 2)})
+	sin := &Frac{
+		Num: NewExp([]factor.Value{factor.D(2, 1), t}),
+		Den: den,
+	}
+	cos := &Frac{
+		Num: NewExp(one, []factor.Value{factor.D(-1, 1), factor.Sp(t.Symbol(), 2)}),
+		Den: den,
+	}
+	var fs []*Frac
+	if e != nil {
+		for _, term := range e.terms {
+			rest := []factor.Value{factor.R(term.Coeff)}
+			var ps, pc int
+			for _, v := range term.Fact {
+				switch {
+				case v.Root() != 1:
+					rest = append(rest, v)
+				case v.Symbol() == s:
+					ps = v.Pow()
+				case v.Symbol() == c:
+					pc = v.Pow()
+				default:
+					rest = append(rest, v)
+				}
+			}
+			fs = append(fs, Ratio(NewExp(rest)).Mul(sin.Pow(ps)).Mul(cos.Pow(pc)))
+		}
+	}
+	return SumFrac(fs...)
+}
+
+// trigAngles lists the angles, x, for which e contains both of the
+// symbols sx and cx.
+func trigAngles(e *Exp) []string {
+	syms := make(map[string]bool)
+	for _, v := range e.Symbols() {
+		syms[v.Symbol()] = true
+	}
+	var angles []string
+	for sym := range syms {
+		if strings.HasPrefix(sym, "s") && syms["c"+sym[1:]] {
+			angles = append(angles, sym[1:])
+		}
+	}
+	sort.Strings(angles)
+	return angles
+}
+
 // Common returns the non-numerical factors common to all terms in the
 // supplied expressions as.
 func Common(as ...*Exp) Term {
@@ -358,6 +961,35 @@ done:
 	}
 }
 
+// CancelCommon removes the factors common to all of the terms of a
+// and b, including any negative powers, from both expressions. The
+// sign is chosen so the first term of a2 is not negative. The
+// cancelled factor is returned as factored.
+func CancelCommon(a, b *Exp) (a2, b2 *Exp, factored Term) {
+	factored = Common(a, b)
+	if s := a.String(); s[0] == '-' {
+		factored.Coeff.Neg(factored.Coeff)
+	}
+	inv := NewExp(append([]factor.Value{factor.R(big.NewRat(1, 1).Inv(factored.Coeff))}, factor.Inv(factored.Fact)...))
+	a2 = Mul(a, inv)
+	b2 = Mul(b, inv)
+	return
+}
+
+// Factor splits e into the factor common to all of its terms, both
+// numerical and symbolic, and the remaining expression, such that
+// their product is e. The numerical part of the factor is chosen to
+// leave the rest with coprime integer coefficients.
+func (e *Exp) Factor() (Term, *Exp) {
+	if e.IsZero() {
+		return Term{Coeff: big.NewRat(1, 1)}, NewExp()
+	}
+	c := Common(e)
+	c.Coeff = CommonN(e)
+	inv := NewExp(append([]factor.Value{factor.R(new(big.Rat).Inv(c.Coeff))}, factor.Inv(c.Fact)...))
+	return c, Mul(e, inv)
+}
+
 type FnDef struct {
 	Name string
 	Args []*Frac
@@ -417,11 +1049,37 @@ func (r *Frac) String() string {
 	return fmt.Sprintf("(%s)/%s", ns, ds)
 }
 
+// LaTeX renders a ratio in LaTeX notation. When the numerator is a
+// single negative term, the sign is placed outside of the fraction.
+func (r *Frac) LaTeX() string {
+	if r == nil || r.Num == nil {
+		return "0"
+	}
+	if r.Den.String() == "1" {
+		return r.Num.latex(r.Fns)
+	}
+	sign, num := "", r.Num
+	if len(num.terms) == 1 {
+		for _, t := range num.terms {
+			if t.Coeff.Sign() < 0 {
+				sign = "-"
+				num = Mul(num, NewExp([]factor.Value{factor.D(-1, 1)}))
+			}
+		}
+	}
+	return fmt.Sprintf("%s\\frac{%s}{%s}", sign, num.latex(r.Fns), r.Den.latex(r.Fns))
+}
+
 // NewFrac with no args initializes a ratio value to "0/1". With one
 // arg, it returns a fraction with that arg as the numerator and 1 as
 // the denominator. For all other numbers of args, the last arg is
 // considered the denominator expression, and all of the preceding
 // args are summed to construct the numerator.
+//
+// NewFrac does not check for a zero denominator, since it is used
+// throughout this package where no error can be returned. Code that
+// constructs a fraction from an arbitrary denominator should use
+// NewFracChecked, which returns ErrDivByZero.
 func NewFrac(e ...*Exp) *Frac {
 	switch len(e) {
 	case 0:
@@ -439,12 +1097,30 @@ func NewFrac(e ...*Exp) *Frac {
 	}
 }
 
+// ErrDivByZero indicates that a fraction has a zero denominator.
+var ErrDivByZero = errors.New("division by zero")
+
+// NewFracChecked is the same as NewFrac, except it returns
+// ErrDivByZero if the denominator of the constructed fraction is
+// zero. NewFrac, with fewer than two args, and Ratio never construct
+// such a fraction.
+func NewFracChecked(e ...*Exp) (*Frac, error) {
+	f := NewFrac(e...)
+	if f.Den.IsZero() {
+		return nil, ErrDivByZero
+	}
+	return f, nil
+}
+
 // Ratio breaks an expression into a numerator and a denominator
 // returned in the form of a Frac.
 //
 // This function works by searching through each term in e and
 // extracts a list of reciprocal (power) terms. It then computes the
 // LCP of these terms. This is the denominator. The Numerator is e*d.
+//
+// Ratio returns no error because the denominator it constructs is a
+// product of non-zero symbolic factors, or 1, so it cannot be zero.
 func Ratio(e *Exp) (f *Frac) {
 	f = NewFrac()
 
@@ -462,11 +1138,25 @@ func Ratio(e *Exp) (f *Frac) {
 	return
 }
 
+// DenominatorBasis returns the symbolic factors common to all of the
+// terms of the denominator of f. For a Frac generated by Ratio, this
+// is the least common product of the reciprocal factors found in the
+// original expression. Only monomial factors are reported: a
+// polynomial factor of the denominator, such as a+b in 1/(a+b), is
+// not part of the basis, so the basis of 1/(a+b) is empty.
+func (f *Frac) DenominatorBasis() []factor.Value {
+	if f == nil || f.Den == nil {
+		return nil
+	}
+	return Common(f.Den).Fact
+}
+
 // mergeFns determines a common namespace for all of the functions in
-// f and b. It re-expresses b in those terms, but leaves f
-// unchanged. The common namespace is returned in fns.
+// f and b. It returns c, a copy of b re-expressed in those terms,
+// and leaves both f and b unchanged. The common namespace is returned
+// in fns.
 func (f *Frac) mergeFns(b *Frac) (c *Frac, fns map[string]FnDef) {
-	c = b
+	c = &Frac{Num: b.Num, Den: b.Den, Fns: b.Fns}
 	fns = f.Fns
 	if fns != nil {
 		if c.Fns != nil {
@@ -553,17 +1243,63 @@ func (f *Frac) Substitute(b []factor.Value, c *Frac) *Frac {
 	return f2
 }
 
-// Leading returns the highest power term from an expression.
-func (ex *Exp) Leading() (term Term, err error) {
-	// Find the greatest power symbol term of `a`.
+// Equals determines if two fractions are always equal. This is true
+// when f.Num*g.Den - g.Num*f.Den is zero. A nil numerator is zero
+// and a nil denominator is 1.
+func (f *Frac) Equals(g *Frac) bool {
+	g, _ = f.mergeFns(g)
+	num := func(x *Exp) *Exp {
+		if x == nil {
+			return NewExp()
+		}
+		return x
+	}
+	den := func(x *Exp) *Exp {
+		if x == nil {
+			return NewExp([]factor.Value{factor.D(1, 1)})
+		}
+		return x
+	}
+	return Mul(num(f.Num), den(g.Den)).Equals(Mul(num(g.Num), den(f.Den)))
+}
+
+// LeadSelector chooses the term of an expression to eliminate when
+// dividing or rearranging. The terms are supplied in the sorted order
+// of their factors. See HighestOrder for the default selector.
+type LeadSelector func(terms []Term) (Term, error)
+
+// sortedTerms returns the terms of e in the sorted order of their
+// factors.
+func (e *Exp) sortedTerms() []Term {
+	var keys []string
+	for s := range e.terms {
+		keys = append(keys, s)
+	}
+	sort.Strings(keys)
+	var ts []Term
+	for _, s := range keys {
+		ts = append(ts, e.terms[s])
+	}
+	return ts
+}
+
+// HighestOrder is the default LeadSelector. It picks the term with
+// the highest total power (see factor.Order). Of several terms with
+// that power, the one with the lexicographically smallest product of
+// factors (see factor.Prod) is picked, so the choice does not depend
+// on the order of terms. For example, a*b is picked from
+// a*b+a*c+b*c. It returns factor.ErrDone if no term has a positive
+// power.
+func HighestOrder(terms []Term) (term Term, err error) {
 	n := 0
 	var leading string
-	for s, t := range ex.terms {
+	for _, t := range terms {
 		m := factor.Order(t.Fact)
-		if n > m {
+		if m <= 0 || m < n {
 			continue
 		}
-		if n == m && s > leading {
+		s := factor.Prod(t.Fact...)
+		if m == n && s >= leading {
 			continue
 		}
 		leading = s
@@ -577,6 +1313,12 @@ func (ex *Exp) Leading() (term Term, err error) {
 	return
 }
 
+// Leading returns the highest power term from an expression. Ties
+// are broken as described for HighestOrder.
+func (ex *Exp) Leading() (term Term, err error) {
+	return HighestOrder(ex.sortedTerms())
+}
+
 var (
 	ErrNoAnswer           = errors.New("no valid answer")
 	ErrAmbiguousLeadingFn = errors.New("ambiguous function")
@@ -625,19 +1367,32 @@ func (f *Frac) LeadingFn() (tag string, err error) {
 //
 // If none of these can be found, an error is returned.
 func Rearrange(lhs, rhs *Frac) (left, right *Frac, err error) {
-	var tok string
-	tok, err = lhs.LeadingFn()
-	var lead Term
-	if err == nil {
-		lead = Term{
-			Coeff: big.NewRat(1, 1),
-			Fact:  []factor.Value{factor.S(tok)},
-		}
-	} else {
-		lead, err = lhs.Num.Leading()
-		if err != nil {
-			return
-		}
+	tok, err := lhs.LeadingFn()
+	if err != nil {
+		return RearrangeWith(lhs, rhs, HighestOrder)
+	}
+	return rearrange(lhs, rhs, Term{
+		Coeff: big.NewRat(1, 1),
+		Fact:  []factor.Value{factor.S(tok)},
+	})
+}
+
+// RearrangeWith is the same as Rearrange, except the term of the
+// numerator of lhs to solve for is chosen by sel.
+func RearrangeWith(lhs, rhs *Frac, sel LeadSelector) (left, right *Frac, err error) {
+	lead, err := sel(lhs.Num.sortedTerms())
+	if err != nil {
+		return
+	}
+	return rearrange(lhs, rhs, lead)
+}
+
+// rearrange solves the equation, lhs = rhs, for the lead term of the
+// numerator of lhs.
+func rearrange(lhs, rhs *Frac, lead Term) (left, right *Frac, err error) {
+	if lhs.Den.IsZero() || rhs.Den.IsZero() {
+		err = ErrDivByZero
+		return
 	}
 	div, rem := lhs.Num.Partition(lead.Fact)
 	rhs, fns := lhs.mergeFns(rhs)
@@ -661,6 +1416,10 @@ func Rearrange(lhs, rhs *Frac) (left, right *Frac, err error) {
 		err = ErrNoAnswer
 		return
 	}
+	if right.Den.IsZero() {
+		err = ErrDivByZero
+		return
+	}
 
 	right.Reduce()
 	left.Reduce()
@@ -670,6 +1429,24 @@ func Rearrange(lhs, rhs *Frac) (left, right *Frac, err error) {
 
 var ErrBadFirstChar = errors.New("invalid first character, \"_\"")
 
+// ErrEmptyArg indicates a function call with an empty argument list,
+// f(), or an empty argument, f(a,).
+var ErrEmptyArg = errors.New("empty function argument")
+
+// checkArgs confirms that none of the comma separated arguments, args,
+// of the function, name, are empty.
+func checkArgs(name, args string) error {
+	if strings.TrimSpace(args) == "" {
+		return fmt.Errorf("%s(): %w", name, ErrEmptyArg)
+	}
+	for i, el := range splitList(args) {
+		if strings.TrimSpace(el) == "" {
+			return fmt.Errorf("%s(%s): argument %d: %w", name, args, i+1, ErrEmptyArg)
+		}
+	}
+	return nil
+}
+
 // ParseFrac converts a string into a parsed Frac expression pair, or
 // a list of such expressions. TODO eventually improve this check.
 func ParseFrac(text string) (*Frac, []*Frac, error) {
@@ -681,16 +1458,74 @@ func ParseFrac(text string) (*Frac, []*Frac, error) {
 	return parseFracInt(text)
 }
 
-// gcd returns the greatest common divisor of a and b.
-func gcd(a, b *big.Int) *big.Int {
-	g := big.NewInt(1).GCD(nil, nil, a, b)
-	return g.Abs(g)
-}
-
-// lcm returns the least common multiple of a and b.
-func lcm(a, b *big.Int) *big.Int {
-	g := gcd(a, b)
-	l := big.NewInt(1).Mul(a, b)
+// NewFracFromStrings parses the numerator, num, and the denominator,
+// den, as separate expressions and returns their reduced ratio.
+func NewFracFromStrings(num, den string) (*Frac, error) {
+	var fs []*Frac
+	for _, text := range []string{num, den} {
+		f, args, err := ParseFrac(text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %q: %v", text, err)
+		}
+		if args != nil {
+			return nil, fmt.Errorf("unexpected list %q", text)
+		}
+		fs = append(fs, f)
+	}
+	n, d := fs[0], fs[1]
+	if d.Num.IsZero() {
+		return nil, fmt.Errorf("zero denominator %q: %w", den, ErrDivByZero)
+	}
+	d, fns := n.mergeFns(d)
+	r := &Frac{
+		Num: Mul(n.Num, d.Den),
+		Den: Mul(n.Den, d.Num),
+		Fns: fns,
+	}
+	r.Reduce()
+	return r, nil
+}
+
+// Simplify parses a text expression and returns its simplified
+// canonical form. This is the most convenient way to use this
+// package: the expression is fully expanded, like terms are
+// collected, and any numerator and denominator are reduced (see
+// ParseFrac and Frac.Reduce). The trigonometric identity sx^2+cx^2=1
+// is applied for every pair of sx and cx symbols (see
+// ApplyPythagorean). A comma separated list of expressions is
+// simplified element by element.
+func Simplify(s string) (string, error) {
+	r, rs, err := ParseFrac(s)
+	if err != nil {
+		return "", err
+	}
+	if rs == nil {
+		rs = []*Frac{r}
+	}
+	var xs []string
+	for _, r := range rs {
+		for _, angle := range trigAngles(r.Num) {
+			r.Num = r.Num.ApplyPythagorean(angle)
+		}
+		for _, angle := range trigAngles(r.Den) {
+			r.Den = r.Den.ApplyPythagorean(angle)
+		}
+		r.Reduce()
+		xs = append(xs, r.String())
+	}
+	return strings.Join(xs, ","), nil
+}
+
+// gcd returns the greatest common divisor of a and b.
+func gcd(a, b *big.Int) *big.Int {
+	g := big.NewInt(1).GCD(nil, nil, a, b)
+	return g.Abs(g)
+}
+
+// lcm returns the least common multiple of a and b.
+func lcm(a, b *big.Int) *big.Int {
+	g := gcd(a, b)
+	l := big.NewInt(1).Mul(a, b)
 	l = l.Abs(l)
 	l = l.Quo(l, g)
 	return l
@@ -724,27 +1559,615 @@ func CommonN(exs ...*Exp) *big.Rat {
 	return big.NewRat(1, 1).SetFrac(n, d)
 }
 
+// ToIntegerCoeffs scales e so all of its coefficients are coprime
+// integers, and the coefficient of its highest order term is
+// positive. The scaled expression and the scale factor applied to e
+// are returned.
+func (e *Exp) ToIntegerCoeffs() (*Exp, *big.Rat) {
+	if e.IsZero() {
+		return NewExp(), big.NewRat(1, 1)
+	}
+	scale := new(big.Rat).Inv(CommonN(e))
+	if e.lead().Coeff.Sign() < 0 {
+		scale.Neg(scale)
+	}
+	return Mul(e, NewExp([]factor.Value{factor.R(scale)})), scale
+}
+
 // Divide performs long division of one expression with another. It
 // returns the quotient: div, and any remainder: rem.
 func (ex *Exp) Divide(a *Exp) (div, rem *Exp, err error) {
-	lead, err := a.Leading()
+	return ex.DivideCtx(context.Background(), a)
+}
+
+// DivideCtx performs the long division of Divide, but gives up with
+// the error of ctx, ctx.Err(), once ctx is canceled or its deadline
+// passes. Pathological divisions can take a long time.
+func (ex *Exp) DivideCtx(ctx context.Context, a *Exp) (div, rem *Exp, err error) {
+	return ex.divideWith(ctx, a, HighestOrder)
+}
+
+// DivideWith performs long division of one expression with another,
+// eliminating the term of a chosen by sel. It returns the quotient:
+// div, and any remainder: rem.
+func (ex *Exp) DivideWith(a *Exp, sel LeadSelector) (div, rem *Exp, err error) {
+	return ex.divideWith(context.Background(), a, sel)
+}
+
+// divideWith implements DivideWith, giving up once ctx is done.
+func (ex *Exp) divideWith(ctx context.Context, a *Exp, sel LeadSelector) (div, rem *Exp, err error) {
+	lead, err := sel(a.sortedTerms())
 	if err != nil {
 		return nil, nil, err
 	}
 	// Express this leading term as _factor-"the rest" of `a`.
 	repl := []factor.Value{factor.S("_factor")}
 	inv := big.NewRat(1, 1).Inv(lead.Coeff)
-	leader := NewExp(append([]factor.Value{factor.R(lead.Coeff)}, lead.Fact...))
+	leader := NewExp(lead.factors())
 	rest := NewExp(repl).Add(leader).Sub(a).Mul(NewExp([]factor.Value{factor.R(inv)}))
-	simple := ex.Substitute(lead.Fact, rest)
+	simple, _, err := ex.substituted(ctx, lead.Fact, rest)
+	if err != nil {
+		return nil, nil, err
+	}
 	x, y := simple.Partition(repl)
 	if x == nil {
 		return nil, nil, factor.ErrDone
 	}
-	div = x.Substitute(repl, a)
+	if div, _, err = x.substituted(ctx, repl, a); err != nil {
+		return nil, nil, err
+	}
 	return div, y, nil
 }
 
+// univariate confirms that e is a polynomial in, at most, the single
+// symbol sym.
+func (e *Exp) univariate(sym factor.Value) error {
+	if e == nil {
+		return nil
+	}
+	for _, t := range e.terms {
+		for _, v := range t.Fact {
+			if v.Symbol() != sym.Symbol() {
+				return fmt.Errorf("%v is not univariate in %v", e, sym)
+			}
+			if v.Pow() < 0 {
+				return fmt.Errorf("%v is not a polynomial in %v", e, sym)
+			}
+		}
+	}
+	return nil
+}
+
+// GCD computes the greatest common divisor of two polynomials in the
+// single symbol sym using the Euclidean algorithm. The result is
+// normalized to be monic (a leading coefficient of 1).
+func GCD(a, b *Exp, sym factor.Value) (*Exp, error) {
+	if err := a.univariate(sym); err != nil {
+		return nil, err
+	}
+	if err := b.univariate(sym); err != nil {
+		return nil, err
+	}
+	for !b.IsZero() {
+		if b.Degree(sym) == 0 {
+			// Non-zero constants divide everything.
+			return NewExp(one), nil
+		}
+		_, r, err := a.Divide(b)
+		if err == factor.ErrDone {
+			// The degree of a is lower than that of b.
+			r = a
+		} else if err != nil {
+			return nil, err
+		}
+		a, b = b, r
+	}
+	if a.IsZero() {
+		return NewExp(), nil
+	}
+	n, _ := a.LeadingCoeff(sym).AsNumber()
+	inv := new(big.Rat).Inv(n)
+	return Mul(a, NewExp([]factor.Value{factor.R(inv)})), nil
+}
+
+// Derivative returns the derivative of e with respect to the symbol
+// sym. All other symbols are treated as constants.
+func (e *Exp) Derivative(sym factor.Value) *Exp {
+	d := NewExp()
+	if e == nil {
+		return d
+	}
+	name := sym.Symbol()
+	for _, t := range e.terms {
+		for i, v := range t.Fact {
+			if v.Symbol() != name {
+				continue
+			}
+			p, r := v.Pow(), v.Root()
+			c := new(big.Rat).Mul(t.Coeff, big.NewRat(int64(p), int64(r)))
+			fs := []factor.Value{factor.R(c), factor.Sr(name, p-r, r)}
+			fs = append(fs, t.Fact[:i]...)
+			fs = append(fs, t.Fact[i+1:]...)
+			d = d.Add(NewExp(fs))
+			break
+		}
+	}
+	return d
+}
+
+// Integrate returns the indefinite integral of e with respect to the
+// symbol sym, omitting the constant of integration. All other symbols
+// are treated as constants. Since the integral of sym^-1 is not a
+// polynomial, such a term results in an error.
+func (e *Exp) Integrate(sym factor.Value) (*Exp, error) {
+	d := NewExp()
+	if e == nil {
+		return d, nil
+	}
+	name := sym.Symbol()
+	for _, t := range e.terms {
+		p, r := 0, 1
+		var fs []factor.Value
+		for _, v := range t.Fact {
+			if v.Symbol() == name {
+				p, r = v.Pow(), v.Root()
+				continue
+			}
+			fs = append(fs, v)
+		}
+		if p == -r {
+			return nil, fmt.Errorf("integral of %v is a log function", Term{Coeff: t.Coeff, Fact: t.Fact}.Exp())
+		}
+		c := new(big.Rat).Mul(t.Coeff, big.NewRat(int64(r), int64(p+r)))
+		fs = append(fs, factor.R(c), factor.Sr(name, p+r, r))
+		d = d.Add(NewExp(fs))
+	}
+	return d, nil
+}
+
+// quotient returns a/b for a b that is known to divide a exactly.
+func quotient(a, b *Exp) (*Exp, error) {
+	if a.IsZero() {
+		return NewExp(), nil
+	}
+	if n, ok := b.AsNumber(); ok && n.Sign() != 0 {
+		return Mul(a, NewExp([]factor.Value{factor.R(new(big.Rat).Inv(n))})), nil
+	}
+	div, rem, err := a.Divide(b)
+	if err != nil {
+		return nil, err
+	}
+	if rem != nil {
+		return nil, fmt.Errorf("%v does not divide %v", b, a)
+	}
+	return div, nil
+}
+
+// SquareFree computes the square-free factorization of a polynomial
+// in the single symbol sym. The returned slice holds the product of
+// the factors of each multiplicity: element i is the product of
+// factors that appear exactly i+1 times. The first element also
+// absorbs the leading coefficient of e, so the product of all of
+// the elements raised to their multiplicity is e. An e that is
+// already square-free is returned as the only element.
+func (e *Exp) SquareFree(sym factor.Value) ([]*Exp, error) {
+	if err := e.univariate(sym); err != nil {
+		return nil, err
+	}
+	if e.Degree(sym) <= 0 {
+		return []*Exp{e}, nil
+	}
+	d := e.Derivative(sym)
+	g, err := GCD(e, d, sym)
+	if err != nil {
+		return nil, err
+	}
+	if g.Degree(sym) == 0 {
+		return []*Exp{e}, nil
+	}
+	// Yun's algorithm.
+	w, err := quotient(e, g)
+	if err != nil {
+		return nil, err
+	}
+	y, err := quotient(d, g)
+	if err != nil {
+		return nil, err
+	}
+	z := y.Sub(w.Derivative(sym))
+	var fs []*Exp
+	for w.Degree(sym) > 0 {
+		h, err := GCD(w, z, sym)
+		if err != nil {
+			return nil, err
+		}
+		fs = append(fs, h)
+		if w, err = quotient(w, h); err != nil {
+			return nil, err
+		}
+		if y, err = quotient(z, h); err != nil {
+			return nil, err
+		}
+		z = y.Sub(w.Derivative(sym))
+	}
+	// What remains of w is the leading coefficient of e.
+	fs[0] = Mul(fs[0], w)
+	return fs, nil
+}
+
+// SolveLinear treats e as the equation e = 0 and solves it for the
+// symbol sym. The returned expression is the value of sym. An error
+// is returned if e is not linear in sym, or if the coefficient of
+// sym is not a single term (use Rearrange for such cases).
+func (e *Exp) SolveLinear(sym factor.Value) (*Exp, error) {
+	x := factor.S(sym.Symbol())
+	for p := range e.Collect(x) {
+		if p != 0 && p != 1 {
+			return nil, fmt.Errorf("%v is not linear in %v: found power %d", e, x, p)
+		}
+	}
+	div, rem := e.Partition([]factor.Value{x})
+	if div == nil {
+		return nil, fmt.Errorf("%v does not contain %v", e, x)
+	}
+	if len(div.terms) != 1 {
+		return nil, fmt.Errorf("coefficient of %v, %v, is not a single term", x, div)
+	}
+	for _, t := range div.terms {
+		inv := new(big.Rat).Inv(t.Coeff)
+		inv.Neg(inv)
+		return Mul(rem, NewExp(append([]factor.Value{factor.R(inv)}, factor.Inv(t.Fact)...))), nil
+	}
+	return nil, ErrNoAnswer
+}
+
+// squareFactor splits the integer n into s^2*r, returning s and r.
+// Only square factors of primes below 2^16 are found, so r is
+// square-free unless n has a large repeated prime factor. The sign
+// of n is kept in r.
+func squareFactor(n *big.Int) (s, r *big.Int) {
+	s, r = big.NewInt(1), new(big.Int).Set(n)
+	d, dd, q, m := new(big.Int), new(big.Int), new(big.Int), new(big.Int)
+	for i := int64(2); i < 1<<16; i++ {
+		d.SetInt64(i)
+		dd.Mul(d, d)
+		if dd.CmpAbs(r) > 0 {
+			break
+		}
+		for {
+			q.QuoRem(r, dd, m)
+			if m.Sign() != 0 {
+				break
+			}
+			r.Set(q)
+			s.Mul(s, d)
+		}
+	}
+	return
+}
+
+// SolveQuadratic treats e as the equation e = 0 and solves it for
+// the symbol sym. The expression must be exactly of degree 2 in sym.
+// The two roots are returned. Unless the discriminant is the square
+// of a rational number, the roots contain a "sqrt" function of it.
+// Square factors are taken out of a numerical discriminant, so the
+// roots of x^2-2 are sqrt(2) and -sqrt(2).
+func (e *Exp) SolveQuadratic(sym factor.Value) ([]*Frac, error) {
+	x := factor.S(sym.Symbol())
+	cs := e.Collect(x)
+	for p := range cs {
+		if p < 0 || p > 2 {
+			return nil, fmt.Errorf("%v is not quadratic in %v: found power %d", e, x, p)
+		}
+	}
+	a, ok := cs[2]
+	if !ok {
+		return nil, fmt.Errorf("%v is not quadratic in %v", e, x)
+	}
+	b, c := Sum(cs[1]), Sum(cs[0])
+	four := NewExp([]factor.Value{factor.D(4, 1)})
+	disc := Mul(b, b).Sub(Mul(four, a, c))
+
+	var fns map[string]FnDef
+	root := NewExp([]factor.Value{factor.S("_FN0FN_")})
+	if n, ok := disc.AsNumber(); ok || disc.IsZero() {
+		if q, ok := factor.RatRoot(n, 2); ok {
+			root = Rat(q)
+		} else {
+			// sqrt(p/q) = sqrt(s^2*r)/q = s/q*sqrt(r).
+			s, r := squareFactor(new(big.Int).Mul(n.Num(), n.Denom()))
+			disc = NewExp([]factor.Value{factor.I(r)})
+			root = Mul(root, Rat(new(big.Rat).SetFrac(s, n.Denom())))
+		}
+	}
+	if root.Contains([]factor.Value{factor.S("_FN0FN_")}) {
+		fns = map[string]FnDef{
+			"_FN0FN_": {Name: "sqrt", Args: []*Frac{NewFrac(disc)}},
+		}
+	}
+	den := Mul(NewExp([]factor.Value{factor.D(2, 1)}), a)
+	var roots []*Frac
+	for _, sign := range []int64{1, -1} {
+		r := &Frac{
+			Num: Mul(root, NewExp([]factor.Value{factor.D(sign, 1)})).Sub(b),
+			Den: den,
+		}
+		if fns != nil {
+			r.Fns = map[string]FnDef{"_FN0FN_": fns["_FN0FN_"]}
+		}
+		r.Reduce()
+		roots = append(roots, r)
+	}
+	return roots, nil
+}
+
+// FactorQuadratic factors e, a quadratic in sym with numerical
+// coefficients, a*x^2+b*x+c, into two linear factors in sym whose
+// product is e. This is only possible when the discriminant,
+// b^2-4*a*c, is the square of a rational number; otherwise, or if e
+// is not such a quadratic, false is returned. For example, x^2-1
+// factors as x-1 and x+1.
+func (e *Exp) FactorQuadratic(sym factor.Value) (*Exp, *Exp, bool) {
+	x := factor.S(sym.Symbol())
+	cs := e.Collect(x)
+	var k [3]*big.Rat
+	for p, c := range cs {
+		n, ok := c.AsNumber()
+		if !ok || p < 0 || p > 2 {
+			return nil, nil, false
+		}
+		k[p] = n
+	}
+	a, b, c := k[2], k[1], k[0]
+	if a == nil || a.Sign() == 0 {
+		return nil, nil, false
+	}
+	if b == nil {
+		b = new(big.Rat)
+	}
+	if c == nil {
+		c = new(big.Rat)
+	}
+	disc := new(big.Rat).Mul(b, b)
+	disc.Sub(disc, new(big.Rat).Mul(big.NewRat(4, 1), new(big.Rat).Mul(a, c)))
+	d, ok := factor.RatRoot(disc, 2)
+	if !ok {
+		return nil, nil, false
+	}
+	// e = a*(x-r1)*(x-r2). With r1 = p/q, the first factor is
+	// q*x-p and the second is a/q*(x-r2).
+	twoA := new(big.Rat).Mul(big.NewRat(2, 1), a)
+	r1 := new(big.Rat).Sub(d, b)
+	r1.Quo(r1, twoA)
+	r2 := new(big.Rat).Neg(d)
+	r2.Sub(r2, b)
+	r2.Quo(r2, twoA)
+	q := new(big.Rat).SetInt(r1.Denom())
+	aq := new(big.Rat).Quo(a, q)
+	f1 := NewExp([]factor.Value{factor.R(q), x}, []factor.Value{factor.R(new(big.Rat).Neg(new(big.Rat).SetInt(r1.Num())))})
+	f2 := NewExp([]factor.Value{factor.R(aq), x}, []factor.Value{factor.R(new(big.Rat).Neg(r2.Mul(r2, aq)))})
+	return f1, f2, true
+}
+
+// degree returns the exact total power of the symbols in fact.
+func degree(fact []factor.Value) *big.Rat {
+	d := new(big.Rat)
+	for _, v := range fact {
+		if v.Symbol() != "" {
+			d.Add(d, big.NewRat(int64(v.Pow()), int64(v.Root())))
+		}
+	}
+	return d
+}
+
+// grlex compares two products of factors in graded lexical order.
+// The result is positive if a is the higher order product, negative
+// if b is, and zero if they are the same. Fractional powers are
+// compared exactly.
+func grlex(a, b []factor.Value) int {
+	if d := degree(a).Cmp(degree(b)); d != 0 {
+		return d
+	}
+	for i, j := 0, 0; i < len(a) || j < len(b); {
+		var x, y factor.Value
+		if i < len(a) {
+			x = a[i]
+		}
+		if j < len(b) {
+			y = b[j]
+		}
+		switch {
+		case j == len(b) || (i < len(a) && x.Symbol() < y.Symbol()):
+			return x.Pow()
+		case i == len(a) || x.Symbol() > y.Symbol():
+			return -y.Pow()
+		}
+		if d := factor.CmpPow(x, y); d != 0 {
+			return d
+		}
+		i++
+		j++
+	}
+	return 0
+}
+
+// lead returns the highest graded lexical order term of e.
+func (e *Exp) lead() (term Term) {
+	first := true
+	for _, t := range e.terms {
+		if first || grlex(t.Fact, term.Fact) > 0 {
+			term = t
+			first = false
+		}
+	}
+	return
+}
+
+// pow returns e raised to the non-negative integer power n.
+func (e *Exp) pow(n int) *Exp {
+	r := NewExp(one)
+	for ; n > 0; n-- {
+		r = Mul(r, e)
+	}
+	return r
+}
+
+// Pow returns e raised to the non-negative integer power n. For n=0
+// the result is 1. Negative powers are not supported by an Exp,
+// instead use a Frac.
+func (e *Exp) Pow(n int) (*Exp, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("negative power %d of %v, use a Frac", n, e)
+	}
+	return e.pow(n), nil
+}
+
+// Binomial expands (a+b)^n, for non-negative n, as the sum over k of
+// C(n,k)*a^(n-k)*b^k. This avoids the intermediate expressions of
+// repeatedly multiplying by (a+b).
+func Binomial(a, b *Exp, n int) (*Exp, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("negative power %d of (%v)+(%v), use a Frac", n, a, b)
+	}
+	as := []*Exp{NewExp(one)}
+	bs := []*Exp{NewExp(one)}
+	for k := 1; k <= n; k++ {
+		as = append(as, Mul(as[k-1], a))
+		bs = append(bs, Mul(bs[k-1], b))
+	}
+	var es []*Exp
+	c := new(big.Int)
+	for k := 0; k <= n; k++ {
+		c.Binomial(int64(n), int64(k))
+		es = append(es, Mul(NewExp([]factor.Value{factor.I(c)}), as[n-k], bs[k]))
+	}
+	return Sum(es...), nil
+}
+
+// Chebyshev generates the n-th Chebyshev polynomial of the first
+// (kind=1) or second (kind=2) kind in the symbol sym. They follow the
+// recurrence P(n+1) = 2*sym*P(n) - P(n-1), with P(0) = 1 and P(1) =
+// sym for the first kind or 2*sym for the second. The first kind
+// expresses cos(n*t) in terms of cos(t), for example T(2) =
+// 2*x^2-1.
+func Chebyshev(kind int, n int, sym factor.Value) (*Exp, error) {
+	if kind != 1 && kind != 2 {
+		return nil, fmt.Errorf("no Chebyshev polynomials of kind %d", kind)
+	}
+	if n < 0 {
+		return nil, fmt.Errorf("negative Chebyshev degree %d", n)
+	}
+	x := factor.S(sym.Symbol())
+	twoX := NewExp([]factor.Value{factor.D(2, 1), x})
+	prev, p := NewExp(one), NewExp([]factor.Value{factor.D(int64(kind), 1), x})
+	if n == 0 {
+		return prev, nil
+	}
+	for i := 1; i < n; i++ {
+		prev, p = p, Mul(twoX, p).Sub(prev)
+	}
+	return p, nil
+}
+
+// MultipleAngle expands c<n*base> (trig="c") or s<n*base> (trig="s")
+// in terms of c<base> and s<base>, following the naming convention of
+// the rotation package. The canonical form is the Chebyshev one:
+// c<n*base> is T(n) of c<base>, a polynomial in c<base> alone, and
+// s<n*base> is s<base> times U(n-1) of c<base>. For example, c2t
+// expands to 2*ct^2-1 and s2t to 2*ct*st. Negative n uses the
+// symmetry of cosine and the antisymmetry of sine.
+func MultipleAngle(trig string, n int, base string) (*Exp, error) {
+	if trig != "c" && trig != "s" {
+		return nil, fmt.Errorf("unknown trigonometric prefix %q", trig)
+	}
+	if !factor.ValidSymbol(base) {
+		return nil, fmt.Errorf("invalid angle %q", base)
+	}
+	neg := n < 0
+	if neg {
+		n = -n
+	}
+	c := factor.S("c" + base)
+	if trig == "c" {
+		return Chebyshev(1, n, c)
+	}
+	if n == 0 {
+		return NewExp(), nil
+	}
+	u, err := Chebyshev(2, n-1, c)
+	if err != nil {
+		return nil, err
+	}
+	s := NewExp([]factor.Value{factor.S("s" + base)})
+	if neg {
+		s = NewExp([]factor.Value{factor.D(-1, 1), factor.S("s" + base)})
+	}
+	return Mul(s, u), nil
+}
+
+// root attempts to find the expression whose n-th power is e. The
+// leading term of e is lt.
+func (e *Exp) root(n int, lt Term) *Exp {
+	c, ok := factor.RatRoot(lt.Coeff, n)
+	if !ok {
+		return nil
+	}
+	lr := []factor.Value{factor.R(c)}
+	for _, v := range lt.Fact {
+		lr = append(lr, factor.Sp(v.Symbol(), v.Pow()/n))
+	}
+	r := NewExp(lr)
+	lr = r.lead().Fact
+	// The leading term of n*r^(n-1).
+	div := big.NewRat(int64(n), 1)
+	div.Mul(div, ratPow(c, n-1))
+	for i := 0; i <= len(e.terms); i++ {
+		rem := e.Sub(r.pow(n))
+		if rem.IsZero() {
+			return r
+		}
+		rt := rem.lead()
+		fs := []factor.Value{factor.R(new(big.Rat).Quo(rt.Coeff, div))}
+		fs = append(fs, rt.Fact...)
+		for _, v := range lr {
+			fs = append(fs, factor.Sp(v.Symbol(), -(n-1)*v.Pow()))
+		}
+		t := NewExp(fs)
+		if grlex(t.lead().Fact, lr) >= 0 {
+			return nil
+		}
+		r = r.Add(t)
+	}
+	return nil
+}
+
+// AsPower determines if e is equal to base^exp for some expression,
+// base, and integer exp > 1. When more than one exponent is possible,
+// the largest is returned. Constant expressions are not considered.
+func (e *Exp) AsPower() (base *Exp, exp int, ok bool) {
+	if e == nil || len(e.terms) == 0 {
+		return
+	}
+	lt := e.lead()
+	g := big.NewInt(0)
+	for _, v := range lt.Fact {
+		g = gcd(g, big.NewInt(int64(v.Pow())))
+	}
+	for n := int(g.Int64()); n > 1; n-- {
+		divides := true
+		for _, v := range lt.Fact {
+			divides = divides && v.Pow()%n == 0
+		}
+		if !divides {
+			continue
+		}
+		if base = e.root(n, lt); base != nil {
+			return base, n, true
+		}
+	}
+	return nil, 0, false
+}
+
 // SubstitutedFn replaces all conforming occurrences of fn.Name in f with c.
 // The boolean return value is true if a substitution was made.
 func (f *Frac) SubstitutedFn(fn FnDef, c *Frac) (*Frac, bool) {
@@ -791,6 +2214,166 @@ func (f *Frac) SubstitutedFn(fn FnDef, c *Frac) (*Frac, bool) {
 	return f, changed
 }
 
+// SubstituteFn expands each application of the function, name, with
+// len(params) arguments in f. Each is replaced by body with its params
+// replaced by the arguments of that application. Every power of a
+// parameter is replaced, including the negative powers.
+func (f *Frac) SubstituteFn(name string, params []factor.Value, body *Exp) *Frac {
+	fn := FnDef{Name: name}
+	for _, p := range params {
+		if p.Symbol() == "" {
+			return f
+		}
+		fn.Args = append(fn.Args, NewFrac(NewExp([]factor.Value{factor.S(p.Symbol())})))
+	}
+	g, _ := f.SubstitutedFn(fn, Ratio(body))
+	return g
+}
+
+// AsPolynomial returns f as a single expression when, once reduced,
+// its numerator is exactly divisible by its denominator. Fractions
+// that reference functions are not polynomials. f is not modified.
+func (f *Frac) AsPolynomial() (*Exp, bool) {
+	g := &Frac{Num: f.Num, Den: f.Den, Fns: f.Fns}
+	g.Reduce()
+	if len(g.Fns) != 0 {
+		return nil, false
+	}
+	if d, ok := g.Den.AsNumber(); ok {
+		if d.Sign() == 0 {
+			return nil, false
+		}
+		return Mul(g.Num, Rat(new(big.Rat).Inv(d))), true
+	}
+	div, rem, err := g.Num.Divide(g.Den)
+	if err != nil || !rem.IsZero() {
+		return nil, false
+	}
+	return div, true
+}
+
+// limitCancels bounds the number of common factor cancellations
+// attempted by Limit.
+const limitCancels = 4
+
+// Limit computes the limit of the rational function f as the symbol
+// sym approaches the value at. Where the numerator and denominator
+// both vanish, their polynomial GCD is cancelled and the evaluation
+// is retried. False is returned if f is not a rational function of
+// sym alone, if the limit is infinite, or if it remains 0/0 after a
+// bounded number of cancellations.
+func (f *Frac) Limit(sym factor.Value, at *big.Rat) (*big.Rat, bool) {
+	if len(f.Fns) != 0 {
+		return nil, false
+	}
+	num, den := Sum(f.Num), Sum(f.Den)
+	vals := map[string]*big.Rat{sym.Symbol(): at}
+	for i := 0; i < limitCancels; i++ {
+		if num.IsZero() {
+			return new(big.Rat), true
+		}
+		n, err := num.Eval(vals)
+		if err != nil {
+			return nil, false
+		}
+		d, err := den.Eval(vals)
+		if err != nil {
+			return nil, false
+		}
+		if d.Sign() != 0 {
+			return n.Quo(n, d), true
+		}
+		if n.Sign() != 0 {
+			return nil, false
+		}
+		g, err := GCD(num, den, sym)
+		if err != nil || g.Degree(sym) == 0 {
+			return nil, false
+		}
+		var rn, rd *Exp
+		if num, rn, err = num.Divide(g); err != nil || !rn.IsZero() {
+			return nil, false
+		}
+		if den, rd, err = den.Divide(g); err != nil || !rd.IsZero() {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// Series returns the power series of the rational function f about
+// sym=0, truncated after the term in sym^order. The series is the
+// result of dividing the numerator by the denominator in ascending
+// powers of sym. Other symbols are treated as constants. An error is
+// returned if f is not a ratio of polynomials in sym or if the
+// constant term of the denominator, with respect to sym, is not a
+// non-zero number.
+func (f *Frac) Series(sym factor.Value, order int) (*Exp, error) {
+	if order < 0 {
+		return nil, fmt.Errorf("negative series order %d", order)
+	}
+	if len(f.Fns) != 0 {
+		return nil, fmt.Errorf("series of %v with functions not supported", f)
+	}
+	x := factor.S(sym.Symbol())
+	ns, ds := f.Num.Collect(x), Sum(f.Den).Collect(x)
+	for _, cs := range []map[int]*Exp{ns, ds} {
+		for p := range cs {
+			if p < 0 {
+				return nil, fmt.Errorf("%v is not a ratio of polynomials in %v", f, x)
+			}
+		}
+	}
+	d0, ok := Sum(ds[0]).AsNumber()
+	if !ok || d0.Sign() == 0 {
+		return nil, fmt.Errorf("no series about %v=0 for %v", x, f)
+	}
+	inv := NewExp([]factor.Value{factor.R(new(big.Rat).Inv(d0))})
+	qs := make([]*Exp, order+1)
+	var es []*Exp
+	for k := 0; k <= order; k++ {
+		q := Sum(ns[k])
+		for j := 1; j <= k; j++ {
+			if d, ok := ds[j]; ok {
+				q = q.Sub(Mul(d, qs[k-j]))
+			}
+		}
+		qs[k] = Mul(q, inv)
+		es = append(es, Mul(qs[k], NewExp([]factor.Value{factor.Sp(x.Symbol(), k)})))
+	}
+	return Sum(es...), nil
+}
+
+// Functions returns the distinct function definitions referenced by
+// the numerator or denominator of f. Unused entries of f.Fns are
+// skipped. The result is sorted by function name, then by number of
+// arguments, then by the text of the arguments.
+func (f *Frac) Functions() []FnDef {
+	var fns []FnDef
+	seen := make(map[string]bool)
+	for tok, fn := range f.Fns {
+		sym := []factor.Value{factor.S(tok)}
+		if !(f.Num.Contains(sym) || f.Den.Contains(sym)) {
+			continue
+		}
+		if s := fn.String(); !seen[s] {
+			seen[s] = true
+			fns = append(fns, fn)
+		}
+	}
+	sort.Slice(fns, func(i, j int) bool {
+		a, b := fns[i], fns[j]
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		if len(a.Args) != len(b.Args) {
+			return len(a.Args) < len(b.Args)
+		}
+		return a.String() < b.String()
+	})
+	return fns
+}
+
 // trimFns collapses duplicate function references down to a canonical
 // reference. It also eliminates any unused references.
 func (f *Frac) trimFns() {
@@ -877,10 +2460,157 @@ func (f *Frac) trimFns() {
 	f.Fns = fns
 }
 
+// copyFns returns a copy of the function token map, fns, that shares
+// no storage with it.
+func copyFns(fns map[string]FnDef) map[string]FnDef {
+	if fns == nil {
+		return nil
+	}
+	c := make(map[string]FnDef, len(fns))
+	for tok, fn := range fns {
+		args := make([]*Frac, len(fn.Args))
+		for i, a := range fn.Args {
+			args[i] = SumFrac(a)
+		}
+		c[tok] = FnDef{Name: fn.Name, Args: args}
+	}
+	return c
+}
+
+// SumFrac adds fractions over the least common multiple of their
+// denominators, and reduces the result. With no arguments, it
+// returns 0/1, and with one it returns a copy of that fraction.
+func SumFrac(fs ...*Frac) *Frac {
+	if len(fs) == 0 {
+		return &Frac{Num: NewExp(), Den: NewExp([]factor.Value{factor.D(1, 1)})}
+	}
+	r := &Frac{Num: Sum(fs[0].Num), Den: Sum(fs[0].Den), Fns: copyFns(fs[0].Fns)}
+	if len(fs) == 1 {
+		return r
+	}
+	for _, f := range fs[1:] {
+		g, fns := r.mergeFns(f)
+		// r.Den*q.Den = g.Den*q.Num is the common denominator.
+		q := &Frac{Num: r.Den, Den: g.Den}
+		q.Reduce()
+		r = &Frac{
+			Num: Sum(Mul(r.Num, q.Den), Mul(Sum(g.Num), q.Num)),
+			Den: Mul(r.Den, q.Den),
+			Fns: fns,
+		}
+	}
+	r.Reduce()
+	return r
+}
+
+// Mul returns the reduced product of the fractions f and g.
+func (f *Frac) Mul(g *Frac) *Frac {
+	g, fns := f.mergeFns(g)
+	r := &Frac{
+		Num: Mul(Sum(f.Num), Sum(g.Num)),
+		Den: Mul(f.Den, g.Den),
+		Fns: fns,
+	}
+	r.Reduce()
+	return r
+}
+
+// Div returns the reduced ratio of the fractions f and g.
+func (f *Frac) Div(g *Frac) *Frac {
+	return f.Mul(&Frac{Num: g.Den, Den: Sum(g.Num), Fns: g.Fns})
+}
+
+// Pow returns the reduced fraction f raised to the integer power
+// n. Negative powers invert f.
+func (f *Frac) Pow(n int) *Frac {
+	num, den := Sum(f.Num), f.Den
+	if n < 0 {
+		num, den, n = den, num, -n
+	}
+	r := &Frac{Num: num.pow(n), Den: den.pow(n), Fns: f.Fns}
+	r.Reduce()
+	return r
+}
+
+// commonDivisor returns a non-trivial factor common to the numerator
+// and denominator of f, or nil if none is found. Polynomial factors
+// are only found for symbols in which both the numerator and
+// denominator are univariate.
+func (f *Frac) commonDivisor() *Exp {
+	if f.Num.IsZero() {
+		return nil
+	}
+	if c := Common(f.Num, f.Den); c.Fact != nil {
+		return c.Exp()
+	}
+	// The only divisors of a single term are the symbolic factors
+	// that Common has already ruled out.
+	if len(f.Num.terms) == 1 || len(f.Den.terms) == 1 {
+		return nil
+	}
+	inDen := make(map[string]bool)
+	for _, sym := range f.Den.Symbols() {
+		inDen[sym.Symbol()] = true
+	}
+	for _, sym := range f.Num.Symbols() {
+		if !inDen[sym.Symbol()] {
+			continue
+		}
+		if g, err := GCD(f.Num, f.Den, sym); err == nil && g.Degree(sym) > 0 {
+			return g
+		}
+	}
+	return nil
+}
+
+// IsReduced returns false if the numerator and denominator of f
+// share a symbolic factor or, when both are polynomials in a single
+// common symbol, a polynomial GCD of non-zero degree.
+func (f *Frac) IsReduced() bool {
+	return f.commonDivisor() == nil
+}
+
+// ReduceChecked is the same as Reduce, except it returns
+// ErrDivByZero, leaving f unchanged, if the denominator of f is zero.
+func (f *Frac) ReduceChecked() error {
+	if f.Den.IsZero() {
+		return ErrDivByZero
+	}
+	f.Reduce()
+	return nil
+}
+
 // Reduce removes factors common to the numerator and denominator.
+// Reduce is called on intermediate results throughout this package
+// and so has no error return. Instead, a fraction with a zero
+// denominator is left unchanged, rather than being rewritten as 1/0
+// or 0/0. Use ReduceChecked to detect ErrDivByZero.
 // TODO explore more sophisticated factorization.
 func (f *Frac) Reduce() {
+	if f.Den.IsZero() {
+		return
+	}
 	f.trimFns()
+	f.reduceRoots()
+
+	// Cancel common polynomial factors until none remain.
+	for g := f.commonDivisor(); g != nil; g = f.commonDivisor() {
+		if len(g.terms) == 1 {
+			// A common symbolic factor cancels without division.
+			inv := NewExp(factor.Inv(Common(g).Fact))
+			f.Num, f.Den = Mul(f.Num, inv), Mul(f.Den, inv)
+			continue
+		}
+		num, err := quotient(f.Num, g)
+		if err != nil {
+			break
+		}
+		den, err := quotient(f.Den, g)
+		if err != nil {
+			break
+		}
+		f.Num, f.Den = num, den
+	}
 
 	// Reduce the numerical coefficients.
 	n := CommonN(f.Num)
@@ -893,7 +2623,8 @@ func (f *Frac) Reduce() {
 	f.Num = Mul(f.Num, pN)
 	f.Den = Mul(f.Den, pD)
 
-	// Reduce simple common factors.
+	// Reduce simple common factors. Unlike CancelCommon, this
+	// leaves the signs of the numerator and denominator alone.
 	t := Common(f.Num, f.Den)
 	if t.Fact != nil {
 		inv := NewExp(factor.Inv(t.Fact))
@@ -913,13 +2644,157 @@ func (f *Frac) Reduce() {
 		f.Den = a
 	}
 
-	// Drop any functions that have been canceled.
-	f.trimFns()
+	// Drop any functions that have been canceled.
+	f.trimFns()
+}
+
+// Normalize reduces f and then, if needed, negates both its
+// numerator and denominator so that the highest order term of the
+// denominator has a positive coefficient.
+func (f *Frac) Normalize() {
+	f.Reduce()
+	if f.Den.IsZero() || f.Den.lead().Coeff.Sign() > 0 {
+		return
+	}
+	neg := NewExp([]factor.Value{factor.D(-1, 1)})
+	f.Num = Mul(f.Num, neg)
+	f.Den = Mul(f.Den, neg)
+}
+
+// splitList splits text at each comma that is not nested within
+// parentheses.
+func splitList(text string) []string {
+	var els []string
+	depth, base := 0, 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				els = append(els, text[base:i])
+				base = i + 1
+			}
+		}
+	}
+	return append(els, text[base:])
+}
+
+// numRootPower matches a number raised to a parenthesized fractional
+// power, "2^(1/2)". The leading group prevents matching the trailing
+// digits of a symbol.
+var numRootPower = regexp.MustCompile(`(^|[^\w.])(\d+)\s*(\^|\*\*)\s*\(\s*([+-]?\d+)\s*/\s*(\d+)\s*\)`)
+
+// numericRoots rewrites each irrational fractional power of a number
+// in text as a power of a sqrt (or a two argument root) function of
+// that number: 2^(3/2) -> sqrt(2)^3 and 3^(1/3) -> root(3,3). Exact
+// powers are left for factor.Parse to evaluate. The offset in text
+// of each byte of the rewritten text is returned in at.
+func numericRoots(text string) (string, []int) {
+	var s []string
+	var at []int
+	last := 0
+	for _, m := range numRootPower.FindAllStringSubmatchIndex(text, -1) {
+		p, _ := strconv.Atoi(text[m[8]:m[9]])
+		q, _ := strconv.Atoi(text[m[10]:m[11]])
+		if q == 0 {
+			continue
+		}
+		g := int(new(big.Int).GCD(nil, nil, big.NewInt(int64(p)), big.NewInt(int64(q))).Int64())
+		p, q = p/g, q/g
+		num := text[m[4]:m[5]]
+		n, _ := new(big.Rat).SetString(num)
+		if _, ok := factor.RatRoot(n, q); ok || q == 1 {
+			continue
+		}
+		fn := fmt.Sprintf("root(%s,%d)", num, q)
+		if q == 2 {
+			fn = fmt.Sprintf("sqrt(%s)", num)
+		}
+		if p != 1 {
+			fn = fmt.Sprintf("%s^%d", fn, p)
+		}
+		// The function name must be space separated from any
+		// preceding operator. The rewritten power is located at
+		// the number.
+		s = append(s, text[last:m[3]], " ", fn)
+		at = append(at, offsets(last, m[3]-last-1)...)
+		for i := 0; i <= len(fn); i++ {
+			at = append(at, m[4])
+		}
+		last = m[1]
+	}
+	s = append(s, text[last:])
+	at = append(at, offsets(last, len(text)-last)...)
+	return strings.Join(s, ""), at
+}
+
+// offsets returns the offsets of the n+1 byte positions of a text
+// of length n that starts at offset base of some original text.
+func offsets(base, n int) []int {
+	at := make([]int, n+1)
+	for i := range at {
+		at[i] = base + i
+	}
+	return at
+}
+
+// respan returns the offsets of a text in which the bytes from
+// offset from to offset to of a text with offsets, at, are replaced
+// by n bytes. The replacement bytes are all mapped to at[from].
+func respan(at []int, from, to, n int) []int {
+	s := append([]int(nil), at[:from]...)
+	for ; n > 0; n-- {
+		s = append(s, at[from])
+	}
+	return append(s, at[to:]...)
+}
+
+// relocate rewrites a *ParseError found in err so that it describes
+// the position in orig of the problem. The position of each byte of
+// the text that was parsed is at[pos] in orig. The return value
+// indicates whether err was rewritten.
+func relocate(err error, orig string, at []int) bool {
+	var pe *ParseError
+	if !errors.As(err, &pe) || pe.Pos < 0 || pe.Pos >= len(at) {
+		return false
+	}
+	pe.Input, pe.Pos = orig, at[pe.Pos]
+	return true
 }
 
 // parseFracInt implements Frac text parsing on a string that contains
-// no externally defined "_" symbols.
+// no externally defined "_" symbols. Any *ParseError returned refers
+// to a position in text, although text is rewritten while parsing.
 func parseFracInt(text string) (r *Frac, args []*Frac, err error) {
+	orig := text
+	if els := splitList(text); len(els) > 1 {
+		base := 0
+		for i, el := range els {
+			ra, as, err2 := ParseFrac(el)
+			if err2 != nil {
+				// A relocated *ParseError identifies the element.
+				if err = err2; !relocate(err2, orig, offsets(base, len(el))) {
+					err = fmt.Errorf("list element[%d] = %q: %w", i, el, err2)
+				}
+				args = nil
+				return
+			}
+			if as != nil {
+				err = fmt.Errorf("unexpected sub-comma list element[%d]: %q -> %q", i, el, as)
+				args = nil
+				return
+			}
+			args = append(args, ra)
+			base += len(el) + 1
+		}
+		return
+	}
+	// at holds the offset in orig of each byte of text.
+	text, at := numericRoots(text)
+
 	depth := 0
 	base := -1
 	// This loop breaks the text string into X ( Y ) Z pieces,
@@ -930,6 +2805,15 @@ func parseFracInt(text string) (r *Frac, args []*Frac, err error) {
 	fns := make(map[string]FnDef)
 	for i := 0; i < len(text); i++ {
 		c := text[i]
+		if c == '(' && depth == 0 {
+			// A parenthesized power is parsed by factor.Parse.
+			if pre := strings.TrimRight(text[:i], " \t"); strings.HasSuffix(pre, "^") || strings.HasSuffix(pre, "**") {
+				if j := strings.Index(text[i:], ")"); j != -1 {
+					i += j
+					continue
+				}
+			}
+		}
 		if c == '(' {
 			if depth == 0 && base == -1 {
 				base = i
@@ -938,14 +2822,23 @@ func parseFracInt(text string) (r *Frac, args []*Frac, err error) {
 		} else if c == ')' {
 			depth--
 			if depth == 0 {
+				if fields := strings.Fields(text[:base]); len(fields) >= 1 {
+					if name := fields[len(fields)-1]; factor.ValidSymbol(name) {
+						if err = checkArgs(name, text[base+1:i]); err != nil {
+							return
+						}
+					}
+				}
 				r2, a2, err2 := ParseFrac(text[base+1 : i])
 				if err2 != nil {
+					relocate(err2, orig, at[base+1:])
 					err = err2
 					return
 				}
 				fields := strings.Fields(text[:base])
 				if len(fields) >= 1 {
 					if name := fields[len(fields)-1]; factor.ValidSymbol(name) {
+						start := len(strings.TrimRight(text[:base], " \t")) - len(name)
 						fn := fmt.Sprintf("_FN%dFN_", len(fns))
 						if a2 != nil {
 							fns[fn] = FnDef{
@@ -958,9 +2851,9 @@ func parseFracInt(text string) (r *Frac, args []*Frac, err error) {
 								Args: []*Frac{r2},
 							}
 						}
-						left := strings.Join(fields[:len(fields)-1], " ")
-						text = fmt.Sprintf("%s %s %s", left, fn, text[i+1:])
-						i = len(left) + 1 + len(fn)
+						text = fmt.Sprintf("%s %s %s", text[:start], fn, text[i+1:])
+						at = respan(at, start, i+1, len(fn)+2)
+						i = start + 1 + len(fn)
 						base = -1
 						continue
 					}
@@ -969,6 +2862,7 @@ func parseFracInt(text string) (r *Frac, args []*Frac, err error) {
 				subs[sub] = r2
 				// Replace with sub and explore rest.
 				text = fmt.Sprintf("%s %s %s", text[:base], sub, text[i+1:])
+				at = respan(at, base, i+1, len(sub)+2)
 				i = base + len(sub) - 1
 				base = -1
 				continue
@@ -984,29 +2878,21 @@ func parseFracInt(text string) (r *Frac, args []*Frac, err error) {
 		return
 	}
 
-	if strings.Contains(text, ",") {
-		for i, el := range strings.Split(text, ",") {
-			ra, as, err2 := ParseFrac(el)
-			if err2 != nil {
-				err = fmt.Errorf("list element[%d] = %q: %v", i, el, err2)
-				args = nil
-				return
-			}
-			if as != nil {
-				err = fmt.Errorf("unexpected sub-comma list element[%d]: %q -> %q", i, el, as)
-				args = nil
-				return
-			}
-			args = append(args, ra)
-		}
-		return
-	}
-
 	e, err2 := ParseExp(text)
 	if err2 != nil {
+		relocate(err2, orig, at)
 		err = err2
 		return
 	}
+	// Only integer powers of parenthesized groups can be expanded.
+	for _, t := range e.terms {
+		for _, v := range t.Fact {
+			if _, ok := subs[v.Symbol()]; ok && v.Root() != 1 {
+				err = fmt.Errorf("unsupported fractional power %d/%d of (%v): %w", v.Pow(), v.Root(), subs[v.Symbol()], factor.ErrSyntax)
+				return
+			}
+		}
+	}
 
 	// Replace each substitution with a numerator and denominator
 	// fraction.
@@ -1035,45 +2921,395 @@ func parseFracInt(text string) (r *Frac, args []*Frac, err error) {
 	return
 }
 
+// ParseError describes where parsing an expression failed. The
+// underlying cause, typically factor.ErrSyntax, is available to
+// errors.Is.
+type ParseError struct {
+	// Input is the text being parsed, and Pos is the byte offset
+	// within it where the problem was found.
+	Input string
+	Pos   int
+	Msg   string
+	Err   error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at offset %d of %q: %v", e.Msg, e.Pos, e.Input, e.Err)
+}
+
+// Unwrap returns the underlying cause of the parsing error.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
 // ParseExp parses an expression in the form of a string. Only simple
-// expressions are parsed: nothing involving parentheses.
+// expressions are parsed: nothing involving parentheses. Parsing
+// errors are returned as a *ParseError.
 func ParseExp(s string) (*Exp, error) {
 	s = strings.TrimRight(s, " \t")
 	if len(s) == 0 {
-		return nil, factor.ErrSyntax
+		return nil, &ParseError{Input: s, Msg: "empty expression", Err: factor.ErrSyntax}
 	}
 	e := NewExp()
 	for i := 0; i < len(s); {
 		vs, d, err := factor.Parse(s[i:])
 		switch err {
 		case factor.ErrSyntax:
-			return nil, fmt.Errorf("%q, %v", s[i:], err)
+			return nil, &ParseError{Input: s, Pos: i + d, Msg: "bad term", Err: err}
 		case factor.ErrDone:
 			if i != len(s) && len(vs) == 0 {
-				return nil, fmt.Errorf("%q, %v", s[i:], factor.ErrSyntax)
+				return nil, &ParseError{Input: s, Pos: i + d, Msg: "missing term", Err: factor.ErrSyntax}
 			}
 		case nil:
 		default:
-			return nil, fmt.Errorf("unexpected error, %q: %v", s[i:], err)
+			return nil, &ParseError{Input: s, Pos: i + d, Msg: "unexpected error", Err: err}
 		}
 		i += d
 		e = e.Add(NewExp(vs))
 		if i != len(s) && s[i] == '+' {
 			i++
 			if i == len(s) {
-				return nil, factor.ErrSyntax
+				return nil, &ParseError{Input: s, Pos: i, Msg: "missing term", Err: factor.ErrSyntax}
 			}
 		}
 	}
 	return e, nil
 }
 
+// Marshal serializes e. It is an alias for String, whose output is
+// canonical (its terms are sorted) and is guaranteed to be converted
+// back into an equal expression by Unmarshal.
+func (e *Exp) Marshal() string {
+	return e.String()
+}
+
+// Unmarshal parses an expression serialized with Marshal.
+func Unmarshal(s string) (*Exp, error) {
+	return ParseExp(s)
+}
+
+// Expand parses an expression that may contain parenthesized groups
+// and multiplies them out. The parsed expression must reduce to one
+// with a denominator of 1, and must not contain any functions.
+func Expand(s string) (*Exp, error) {
+	r, args, err := ParseFrac(s)
+	if err != nil {
+		return nil, err
+	}
+	if args != nil {
+		return nil, fmt.Errorf("unexpected list %q", s)
+	}
+	if r.Fns != nil {
+		return nil, fmt.Errorf("unexpected function in %q", s)
+	}
+	r.Reduce()
+	if !r.Den.Equals(NewExp(one)) {
+		return nil, fmt.Errorf("%q has a denominator, %v", s, r.Den)
+	}
+	return r.Num, nil
+}
+
 // Equals compares two expressions and determines if they are always
 // equal.
 func (e *Exp) Equals(x *Exp) bool {
 	return e.Sub(x).IsZero()
 }
 
+// Proportional determines if a is a constant multiple of b, and if so
+// returns the rational constant, k, for which a = k*b. Expressions with
+// different sets of terms are not proportional, and neither are zero
+// expressions.
+func (a *Exp) Proportional(b *Exp) (*big.Rat, bool) {
+	if a.IsZero() || b.IsZero() || len(a.terms) != len(b.terms) {
+		return nil, false
+	}
+	var k *big.Rat
+	for key, t := range a.terms {
+		u, ok := b.terms[key]
+		if !ok || u.Coeff.Sign() == 0 {
+			return nil, false
+		}
+		r := new(big.Rat).Quo(t.Coeff, u.Coeff)
+		if k == nil {
+			k = r
+		} else if k.Cmp(r) != 0 {
+			return nil, false
+		}
+	}
+	return k, true
+}
+
+// Hash computes a hash of e that does not depend on the order in
+// which its terms were constructed. Two expressions with unequal
+// Hash values are never Equal, but equal Hash values do not
+// guarantee that the expressions are Equal.
+func (e *Exp) Hash() uint64 {
+	var sum uint64
+	if e.IsZero() {
+		return sum
+	}
+	for k, t := range e.terms {
+		if t.Coeff.Sign() == 0 {
+			continue
+		}
+		h := fnv.New64a()
+		h.Write([]byte(k))
+		h.Write([]byte{0})
+		h.Write([]byte(t.Coeff.RatString()))
+		// Summing the term hashes makes the result
+		// independent of the map iteration order.
+		sum += h.Sum64()
+	}
+	return sum
+}
+
+// number returns the value of f when it is a rational number.
+func (f *Frac) number() (*big.Rat, bool) {
+	if len(f.Fns) != 0 {
+		return nil, false
+	}
+	n, ok := f.Num.AsNumber()
+	if !ok {
+		return nil, false
+	}
+	d, ok := f.Den.AsNumber()
+	if !ok || d.Sign() == 0 {
+		return nil, false
+	}
+	return new(big.Rat).Quo(n, d), true
+}
+
+// rootDef returns the radicand, n, and index, k, of a numerical
+// root function: sqrt(n) or root(n,k). Only positive radicands are
+// returned.
+func rootDef(fn FnDef) (n *big.Rat, k int, ok bool) {
+	switch {
+	case fn.Name == "sqrt" && len(fn.Args) == 1:
+		k = 2
+	case fn.Name == "root" && len(fn.Args) == 2:
+		r, ok := fn.Args[1].number()
+		if !ok || !r.IsInt() || !r.Num().IsInt64() || r.Num().Int64() < 2 {
+			return nil, 0, false
+		}
+		k = int(r.Num().Int64())
+	default:
+		return nil, 0, false
+	}
+	if n, ok = fn.Args[0].number(); !ok || n.Sign() <= 0 {
+		return nil, 0, false
+	}
+	return n, k, true
+}
+
+// reduceRoots rewrites the powers of the numerical root function
+// tokens of f that are at least as large as the index of the root,
+// so they cancel to rationals: sqrt(2)^3 -> 2*sqrt(2).
+func (f *Frac) reduceRoots() {
+	for tok, fn := range f.Fns {
+		n, k, ok := rootDef(fn)
+		if !ok {
+			continue
+		}
+		f.Num = f.Num.reduceRoot(tok, n, k)
+		f.Den = f.Den.reduceRoot(tok, n, k)
+	}
+}
+
+// reduceRoot replaces each power, p, of the symbol tok in e, standing
+// for the k-th root of n, with n^(p/k) times tok^(p%k).
+func (e *Exp) reduceRoot(tok string, n *big.Rat, k int) *Exp {
+	r := NewExp()
+	for _, t := range e.terms {
+		vs := []factor.Value{factor.R(t.Coeff)}
+		for _, v := range t.Fact {
+			if v.Symbol() != tok || v.Root() != 1 {
+				vs = append(vs, v)
+				continue
+			}
+			q := v.Pow() / k
+			if q < 0 {
+				vs = append(vs, factor.R(new(big.Rat).Inv(ratPow(n, -q))))
+			} else {
+				vs = append(vs, factor.R(ratPow(n, q)))
+			}
+			vs = append(vs, factor.Sp(tok, v.Pow()%k))
+		}
+		r = r.Add(NewExp(vs))
+	}
+	return r
+}
+
+// ratPow raises r to the non-negative integer power p.
+func ratPow(r *big.Rat, p int) *big.Rat {
+	n := big.NewInt(int64(p))
+	num := new(big.Int).Exp(r.Num(), n, nil)
+	den := new(big.Int).Exp(r.Denom(), n, nil)
+	return new(big.Rat).SetFrac(num, den)
+}
+
+// Eval computes the numerical value of e when each of its symbols is
+// replaced by its value in vals. An error is returned if a symbol has
+// no value, or if a negative power of a zero value is encountered.
+func (e *Exp) Eval(vals map[string]*big.Rat) (*big.Rat, error) {
+	sum := new(big.Rat)
+	if e == nil {
+		return sum, nil
+	}
+	for _, t := range e.terms {
+		x := new(big.Rat).Set(t.Coeff)
+		for _, v := range t.Fact {
+			r, ok := vals[v.Symbol()]
+			if !ok {
+				return nil, fmt.Errorf("no value for %q", v.Symbol())
+			}
+			p := v.Pow()
+			if p < 0 {
+				if r.Sign() == 0 {
+					return nil, fmt.Errorf("division by zero: %q=0 in %v", v.Symbol(), e)
+				}
+				r = new(big.Rat).Inv(r)
+				p = -p
+			}
+			r = ratPow(r, p)
+			if q := v.Root(); q != 1 {
+				var ok bool
+				if r, ok = factor.RatRoot(r, q); !ok {
+					return nil, fmt.Errorf("no rational value for %v with %q=%s", v, v.Symbol(), vals[v.Symbol()].RatString())
+				}
+			}
+			x.Mul(x, r)
+		}
+		sum.Add(sum, x)
+	}
+	return sum, nil
+}
+
+// floatFns are the functions understood by EvalFloat.
+var floatFns = map[string]func(float64) float64{
+	"sin":  math.Sin,
+	"cos":  math.Cos,
+	"tan":  math.Tan,
+	"sqrt": math.Sqrt,
+	"exp":  math.Exp,
+	"log":  math.Log,
+}
+
+// evalFloat computes the floating point value of e. Function tokens
+// are looked up in fns.
+func (e *Exp) evalFloat(fns map[string]FnDef, bindings map[string]float64) (float64, error) {
+	sum := 0.0
+	if e == nil {
+		return sum, nil
+	}
+	for _, t := range e.terms {
+		x, _ := t.Coeff.Float64()
+		for _, v := range t.Fact {
+			r, ok := bindings[v.Symbol()]
+			if fn, isFn := fns[v.Symbol()]; isFn {
+				var as []float64
+				for _, arg := range fn.Args {
+					a, err := arg.EvalFloat(bindings)
+					if err != nil {
+						return 0, err
+					}
+					as = append(as, a)
+				}
+				if fn.Name == "root" && len(as) == 2 {
+					r = math.Pow(as[0], 1/as[1])
+				} else if f, ok := floatFns[fn.Name]; !ok {
+					return 0, fmt.Errorf("unknown function %q", fn.Name)
+				} else if len(as) != 1 {
+					return 0, fmt.Errorf("%s takes 1 argument, not %d", fn.Name, len(as))
+				} else {
+					r = f(as[0])
+				}
+			} else if !ok {
+				return 0, fmt.Errorf("no value for %q", v.Symbol())
+			}
+			x *= math.Pow(r, float64(v.Pow())/float64(v.Root()))
+		}
+		sum += x
+	}
+	return sum, nil
+}
+
+// EvalFloat computes the floating point value of f when each of its
+// symbols is replaced by its value in bindings. The functions sin,
+// cos, tan, sqrt, exp and log are evaluated with the math package,
+// as is root(x,n), the n-th root of x. An error is returned for any
+// other function, for a symbol with no value or for a zero
+// denominator.
+func (f *Frac) EvalFloat(bindings map[string]float64) (float64, error) {
+	n, err := f.Num.evalFloat(f.Fns, bindings)
+	if err != nil {
+		return 0, err
+	}
+	d, err := f.Den.evalFloat(f.Fns, bindings)
+	if err != nil {
+		return 0, err
+	}
+	if d == 0 {
+		return 0, fmt.Errorf("division by zero: %v", f)
+	}
+	return n / d, nil
+}
+
+// ProbablyEquals compares two expressions by evaluating them with
+// random non-zero rational values for all of their symbols. Each of
+// the samples sets of values is tried, and any mismatch returns
+// false. This is a probabilistic (Schwartz-Zippel) test: a false
+// return is certain, but a true one only indicates that the
+// expressions are very likely equal. Use Equals for a definitive
+// answer. A symbol raised to fractional powers is given a positive
+// value that is a perfect power, so every power evaluates exactly.
+func (e *Exp) ProbablyEquals(x *Exp, samples int) bool {
+	// syms holds the least common multiple of the roots of the
+	// powers of each symbol.
+	syms := make(map[string]int64)
+	for _, ex := range []*Exp{e, x} {
+		if ex == nil {
+			continue
+		}
+		for _, t := range ex.terms {
+			for _, v := range t.Fact {
+				s := v.Symbol()
+				if s == "" {
+					continue
+				}
+				l, r := syms[s], int64(v.Root())
+				if l == 0 {
+					l = 1
+				}
+				syms[s] = l * r / new(big.Int).GCD(nil, nil, big.NewInt(l), big.NewInt(r)).Int64()
+			}
+		}
+	}
+	for i := 0; i < samples || i == 0; i++ {
+		vals := make(map[string]*big.Rat)
+		for s, l := range syms {
+			if l > 1 {
+				r := big.NewRat(1+rand.Int63n(20), 1+rand.Int63n(20))
+				vals[s] = ratPow(r, int(l))
+				continue
+			}
+			n := rand.Int63n(2000) - 1000
+			if n >= 0 {
+				n++
+			}
+			vals[s] = big.NewRat(n, 1+rand.Int63n(1000))
+		}
+		a, err := e.Eval(vals)
+		if err != nil {
+			return false
+		}
+		b, err := x.Eval(vals)
+		if err != nil || a.Cmp(b) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
 // Symbols returns a sorted array of unique symbols found in an
 // expression. The returned array should be considered a list and not
 // a meaninful product of factors.
@@ -1091,6 +3327,116 @@ func (e *Exp) Symbols() (syms []factor.Value) {
 	return
 }
 
+// FreeSymbols returns the sorted symbols of e, as returned by
+// Symbols, that are not known. Internal "_" prefixed tokens, such as
+// function tokens, are never included.
+func (e *Exp) FreeSymbols(known map[string]bool) (syms []factor.Value) {
+	for _, v := range e.Symbols() {
+		if s := v.Symbol(); !known[s] && !strings.HasPrefix(s, "_") {
+			syms = append(syms, v)
+		}
+	}
+	return
+}
+
+// userSymbols returns the sorted unique symbols, excluding internal
+// "_" prefixed tokens, of the expressions es.
+func userSymbols(es ...*Exp) (syms []factor.Value) {
+	ss := make(map[string]bool)
+	for _, e := range es {
+		if e.IsZero() {
+			continue
+		}
+		for _, v := range e.Symbols() {
+			if s := v.Symbol(); !ss[s] && !strings.HasPrefix(s, "_") {
+				ss[s] = true
+				syms = append(syms, v)
+			}
+		}
+	}
+	sort.Sort(factor.ByAlpha(syms))
+	return
+}
+
+// Symbols returns the sorted unique symbols found in the numerator
+// and denominator of f. Function tokens, and any other internal
+// tokens, are excluded. See AllSymbols to include the symbols of
+// function arguments.
+func (f *Frac) Symbols() []factor.Value {
+	return userSymbols(f.Num, f.Den)
+}
+
+// AllSymbols is the same as Symbols, but also includes the symbols
+// of the arguments of the functions referenced by f.
+func (f *Frac) AllSymbols() []factor.Value {
+	es := []*Exp{f.Num, f.Den}
+	for _, fn := range f.Functions() {
+		for _, a := range fn.Args {
+			for _, v := range a.AllSymbols() {
+				es = append(es, NewExp([]factor.Value{v}))
+			}
+		}
+	}
+	return userSymbols(es...)
+}
+
+// FreeSymbols returns the symbols of f, including those of its
+// function arguments, that are not known.
+func (f *Frac) FreeSymbols(known map[string]bool) (syms []factor.Value) {
+	for _, v := range f.AllSymbols() {
+		if !known[v.Symbol()] {
+			syms = append(syms, v)
+		}
+	}
+	return
+}
+
+// Rename returns a copy of e with its symbols renamed according to
+// aliases. Powers are preserved, and all of the renames are
+// performed at once, so symbols can be swapped. A rename is skipped
+// if its target is not a valid symbol, or if it would merge two
+// distinct symbols of e.
+func (e *Exp) Rename(aliases map[string]string) *Exp {
+	to := make(map[string]string)
+	for _, v := range e.Symbols() {
+		s := v.Symbol()
+		to[s] = s
+		if a, ok := aliases[s]; ok && factor.ValidSymbol(a) {
+			to[s] = a
+		}
+	}
+	for collided := true; collided; {
+		collided = false
+		from := make(map[string][]string)
+		for s, a := range to {
+			from[a] = append(from[a], s)
+		}
+		for _, ss := range from {
+			if len(ss) == 1 {
+				continue
+			}
+			for _, s := range ss {
+				if to[s] != s {
+					to[s] = s
+					collided = true
+				}
+			}
+		}
+	}
+	r := NewExp()
+	for _, t := range e.terms {
+		fs := []factor.Value{factor.R(t.Coeff)}
+		for _, v := range t.Fact {
+			if s := v.Symbol(); s != "" {
+				v = factor.Sr(to[s], v.Pow(), v.Root())
+			}
+			fs = append(fs, v)
+		}
+		r = r.Add(NewExp(fs))
+	}
+	return r
+}
+
 // AsSubValue confirms that a whole *Frac is one term long and can be
 // expressed as a substitute value.
 func (f *Frac) AsSubValue() ([]factor.Value, bool) {
@@ -1112,3 +3458,140 @@ func (f *Frac) AsSubValue() ([]factor.Value, bool) {
 	}
 	return nil, false
 }
+
+// Step is one recorded operation of a derivation. The inputs and
+// outputs are held in their string form.
+type Step struct {
+	Op      string
+	Inputs  []string
+	Outputs []string
+}
+
+// String renders a step in a human readable form.
+func (s Step) String() string {
+	return fmt.Sprintf("%s(%s) = %s", s.Op, strings.Join(s.Inputs, ", "), strings.Join(s.Outputs, ", "))
+}
+
+// Transcript records a sequence of derivation steps. A nil
+// *Transcript is valid, its methods perform the operations without
+// recording anything.
+type Transcript struct {
+	Steps []Step
+}
+
+// NewTranscript returns an empty transcript.
+func NewTranscript() *Transcript {
+	return &Transcript{}
+}
+
+// record appends a step to the transcript.
+func (t *Transcript) record(op string, ins []string, outs ...string) {
+	if t == nil {
+		return
+	}
+	t.Steps = append(t.Steps, Step{Op: op, Inputs: ins, Outputs: outs})
+}
+
+// Substitute returns f.Substitute(b, c) and records the step.
+func (t *Transcript) Substitute(f *Frac, b []factor.Value, c *Frac) *Frac {
+	r := f.Substitute(b, c)
+	t.record("substitute", []string{f.String(), factor.Prod(b...), c.String()}, r.String())
+	return r
+}
+
+// Reduce returns a reduced copy of f and records the step.
+func (t *Transcript) Reduce(f *Frac) *Frac {
+	r := &Frac{Num: f.Num, Den: f.Den, Fns: f.Fns}
+	r.Reduce()
+	t.record("reduce", []string{f.String()}, r.String())
+	return r
+}
+
+// Rearrange returns Rearrange(lhs, rhs) and records the step when
+// successful.
+func (t *Transcript) Rearrange(lhs, rhs *Frac) (left, right *Frac, err error) {
+	left, right, err = Rearrange(lhs, rhs)
+	if err == nil {
+		t.record("rearrange", []string{lhs.String(), rhs.String()}, left.String(), right.String())
+	}
+	return
+}
+
+// Solve returns e.SolveLinear(sym) and records the step when
+// successful.
+func (t *Transcript) Solve(e *Exp, sym factor.Value) (*Exp, error) {
+	r, err := e.SolveLinear(sym)
+	if err == nil {
+		t.record("solve", []string{e.String(), sym.String()}, r.String())
+	}
+	return r, err
+}
+
+// parseFracs parses a list of expressions into fractions.
+func parseFracs(ss ...string) ([]*Frac, error) {
+	var fs []*Frac
+	for _, s := range ss {
+		f, _, err := ParseFrac(s)
+		if err != nil {
+			return nil, fmt.Errorf("bad input %q: %v", s, err)
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+// Replay re-parses the inputs of each recorded step, performs the
+// step's operation again and confirms that it reproduces the
+// recorded outputs. The rendered steps are returned.
+func (t *Transcript) Replay() ([]string, error) {
+	var lines []string
+	for i, s := range t.Steps {
+		var outs []string
+		switch s.Op {
+		case "substitute":
+			fs, err := parseFracs(s.Inputs[0], s.Inputs[2])
+			if err != nil {
+				return lines, fmt.Errorf("step %d: %v", i, err)
+			}
+			b, _, err := factor.Parse(s.Inputs[1])
+			if err != nil {
+				return lines, fmt.Errorf("step %d: bad symbol %q: %v", i, s.Inputs[1], err)
+			}
+			outs = append(outs, fs[0].Substitute(b, fs[1]).String())
+		case "reduce":
+			fs, err := parseFracs(s.Inputs[0])
+			if err != nil {
+				return lines, fmt.Errorf("step %d: %v", i, err)
+			}
+			fs[0].Reduce()
+			outs = append(outs, fs[0].String())
+		case "rearrange":
+			fs, err := parseFracs(s.Inputs...)
+			if err != nil {
+				return lines, fmt.Errorf("step %d: %v", i, err)
+			}
+			left, right, err := Rearrange(fs[0], fs[1])
+			if err != nil {
+				return lines, fmt.Errorf("step %d: %v", i, err)
+			}
+			outs = append(outs, left.String(), right.String())
+		case "solve":
+			e, err := ParseExp(s.Inputs[0])
+			if err != nil {
+				return lines, fmt.Errorf("step %d: bad input %q: %v", i, s.Inputs[0], err)
+			}
+			r, err := e.SolveLinear(factor.S(s.Inputs[1]))
+			if err != nil {
+				return lines, fmt.Errorf("step %d: %v", i, err)
+			}
+			outs = append(outs, r.String())
+		default:
+			return lines, fmt.Errorf("step %d: unknown operation %q", i, s.Op)
+		}
+		if got, want := strings.Join(outs, ", "), strings.Join(s.Outputs, ", "); got != want {
+			return lines, fmt.Errorf("step %d: %s replayed as %q", i, s, got)
+		}
+		lines = append(lines, s.String())
+	}
+	return lines, nil
+}
@@ -1,9 +1,14 @@
 package terms
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"math/big"
+	"strings"
 	"testing"
+	"time"
 
 	f "zappem.net/pub/math/algex/factor"
 )
@@ -46,6 +51,28 @@ func TestNewExp(t *testing.T) {
 			},
 			s: "2*a^2",
 		},
+		{
+			e: [][]f.Value{
+				{f.S("a"), f.D(1, 1)},
+				{f.D(1, 1)},
+				{f.S("b"), f.D(-1, 1)},
+			},
+			s: "1+a-b",
+		},
+		{
+			e: [][]f.Value{
+				{f.D(-1, 1), f.D(-1, 1), f.Sp("a", 2)},
+				{f.D(1, 1), f.D(-1, 1)},
+			},
+			s: "-1+a^2",
+		},
+		{
+			e: [][]f.Value{
+				{f.D(2, 1), f.D(1, 2), f.S("a")},
+				{f.D(-1, 1), f.Sp("a", 0)},
+			},
+			s: "-1+a",
+		},
 	}
 	for i, v := range vs {
 		e := NewExp(v.e...)
@@ -55,6 +82,35 @@ func TestNewExp(t *testing.T) {
 	}
 }
 
+func TestUnitSigns(t *testing.T) {
+	vs := []struct {
+		from, want, ordered string
+	}{
+		{"1-a", "1-a", "-a+1"},
+		{"-1+a^2", "-1+a^2", "a^2-1"},
+		{"a-1", "-1+a", "a-1"},
+		{"a+1", "1+a", "a+1"},
+		{"-1-a", "-1-a", "-a-1"},
+		{"1*a-1*b", "a-b", "a-b"},
+		{"-1*a*b+1", "1-a*b", "-a*b+1"},
+		{"1", "1", "1"},
+		{"-1", "-1", "-1"},
+		{"1-a^-1", "1-a^-1", "1-a^-1"},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		if got := e.String(); got != v.want {
+			t.Errorf("[%d] %q: got=%q want=%q", i, v.from, got, v.want)
+		}
+		if got := e.StringOrdered(); got != v.ordered {
+			t.Errorf("[%d] %q ordered: got=%q want=%q", i, v.from, got, v.ordered)
+		}
+	}
+}
+
 func TestAddSub(t *testing.T) {
 	a := NewExp([]f.Value{f.Sp("a", 3), f.D(1, 3)},
 		[]f.Value{f.D(2, 3), f.Sp("a", 3)},
@@ -151,6 +207,42 @@ func TestMod(t *testing.T) {
 	}
 }
 
+func TestReduceModP(t *testing.T) {
+	vs := []struct {
+		from string
+		p    int64
+		want string
+	}{
+		{"3*x+5*y", 3, "2*y"},
+		{"-x", 7, "6*x"},
+		{"x/2", 7, "4*x"},
+		{"-2/3*x^2+1/2", 5, "3+x^2"},
+		{"x^5+5*x^4+10*x^3+10*x^2+5*x+1", 5, "1+x^5"},
+		{"7*a-14", 7, "0"},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		got, err := e.ReduceModP(v.p)
+		if err != nil {
+			t.Errorf("[%d] %v mod %d failed: %v", i, e, v.p, err)
+			continue
+		}
+		if s := got.String(); s != v.want {
+			t.Errorf("[%d] %v mod %d: got=%q want=%q", i, e, v.p, s, v.want)
+		}
+	}
+	e, _ := ParseExp("x/6")
+	if got, err := e.ReduceModP(3); err == nil {
+		t.Errorf("%v mod 3 should fail: got=%v", e, got)
+	}
+	if got, err := e.ReduceModP(1); err == nil {
+		t.Errorf("%v mod 1 should fail: got=%v", e, got)
+	}
+}
+
 func TestContains(t *testing.T) {
 	vs := []struct {
 		sym  string
@@ -167,23 +259,32 @@ func TestContains(t *testing.T) {
 	}
 }
 
+var parseExpTests = []struct {
+	from, want string
+}{
+	{"a ", "a"},
+	{"1+1-1", "1"},
+	{"a-b+a", "2*a-b"},
+	{"d1", "d1"},
+	{"d1*d0", "d0*d1"},
+	{"-d1*d1*d0*d1", "-d0*d1^3"},
+	{"a+a*b+b*a-a", "2*a*b"},
+	{"a+a*b+b*a+a-c/2+2/d", "2*a+2*a*b-1/2*c+2*d^-1"},
+	{"a - -b", "a+b"},
+	{"a--b", "a+b"},
+	{"a+-b", "a-b"},
+	{"+a", "a"},
+	{"-+-a", "a"},
+	{"a - - 2", "2+a"},
+	{"a*-b", "-a*b"},
+	{"a/-2", "-1/2*a"},
+}
+
 func TestParseExp(t *testing.T) {
-	vs := []struct {
-		from, want string
-	}{
-		{"a ", "a"},
-		{"1+1-1", "1"},
-		{"a-b+a", "2*a-b"},
-		{"d1", "d1"},
-		{"d1*d0", "d0*d1"},
-		{"-d1*d1*d0*d1", "-d0*d1^3"},
-		{"a+a*b+b*a-a", "2*a*b"},
-		{"a+a*b+b*a+a-c/2+2/d", "2*a+2*a*b-1/2*c+2*d^-1"},
-	}
 	if e, err := ParseExp(" "); err == nil {
 		t.Fatalf("parsed empty as something: %v", e)
 	}
-	for i, v := range vs {
+	for i, v := range parseExpTests {
 		e, err := ParseExp(v.from)
 		if err != nil {
 			t.Errorf("[%d] parsing %q: %v", i, v.from, err)
@@ -245,6 +346,158 @@ func TestFrac(t *testing.T) {
 			t.Errorf("failed to equate %d:a=%q,b=%q -> %q != %q", i, e.a, e.b, ast, bst)
 		}
 	}
+	for i, s := range []string{"f()", "f( )", "f(,)", "f(a,)", "f(,a)", "x + f(a,,b)", "2 * g(f(), x)"} {
+		if a, as, err := ParseFrac(s); !errors.Is(err, ErrEmptyArg) {
+			t.Errorf("[%d] %q: got=%v,%v,%v want=%v", i, s, a, as, err, ErrEmptyArg)
+		}
+	}
+	if a, _, err := ParseFrac("f(a, b)"); err != nil || a.String() != "f(a,b)" {
+		t.Errorf("f(a, b): got=%v, %v", a, err)
+	}
+}
+
+func TestAsPolynomial(t *testing.T) {
+	vs := []struct {
+		from, want string
+	}{
+		{"(x^2-1)/(x-1)", "1+x"},
+		{"6*x/4", "3/2*x"},
+		{"(a^2-b^2)/(a+b)", "a-b"},
+		{"(x^3-y^3)/(x-y)", "x*y+x^2+y^2"},
+		{"x", "x"},
+		{"(x^2+1)/(x-1)", ""},
+		{"a/b", ""},
+		{"1/x", ""},
+		{"sin(x) * x / x", ""},
+	}
+	for i, v := range vs {
+		r, _, err := ParseFrac(v.from)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		before := r.String()
+		e, ok := r.AsPolynomial()
+		if v.want == "" {
+			if ok {
+				t.Errorf("[%d] %q is not a polynomial: got=%v", i, v.from, e)
+			}
+		} else if !ok {
+			t.Errorf("[%d] %q should be a polynomial", i, v.from)
+		} else if got := e.String(); got != v.want {
+			t.Errorf("[%d] %q: got=%q want=%q", i, v.from, got, v.want)
+		}
+		if after := r.String(); after != before {
+			t.Errorf("[%d] %q modified: %q -> %q", i, v.from, before, after)
+		}
+	}
+}
+
+func TestLimit(t *testing.T) {
+	vs := []struct {
+		num, den string
+		at       *big.Rat
+		want     string
+	}{
+		{"x^2-1", "x-1", big.NewRat(1, 1), "2"},
+		{"x^3-1", "x^2-1", big.NewRat(1, 1), "3/2"},
+		{"x+1", "x+2", big.NewRat(0, 1), "1/2"},
+		{"x^2-2*x+1", "x^2-3*x+2", big.NewRat(1, 1), "0"},
+		{"4*x^2-1", "2*x-1", big.NewRat(1, 2), "2"},
+		{"0", "x", big.NewRat(0, 1), "0"},
+		{"1", "x", big.NewRat(0, 1), ""},
+		{"x", "x^3", big.NewRat(0, 1), ""},
+		{"x*y", "x", big.NewRat(0, 1), ""},
+	}
+	for i, v := range vs {
+		num, err := ParseExp(v.num)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.num, err)
+		}
+		den, err := ParseExp(v.den)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.den, err)
+		}
+		r := &Frac{Num: num, Den: den}
+		got, ok := r.Limit(f.S("x"), v.at)
+		if v.want == "" {
+			if ok {
+				t.Errorf("[%d] (%v)/(%v) at %v should have no limit: got=%v", i, num, den, v.at, got)
+			}
+		} else if !ok {
+			t.Errorf("[%d] (%v)/(%v) at %v: no limit", i, num, den, v.at)
+		} else if got.RatString() != v.want {
+			t.Errorf("[%d] (%v)/(%v) at %v: got=%v want=%s", i, num, den, v.at, got.RatString(), v.want)
+		}
+	}
+}
+
+func TestSeries(t *testing.T) {
+	vs := []struct {
+		from  string
+		order int
+		want  string
+	}{
+		{"1/(1-x)", 3, "1+x+x^2+x^3"},
+		{"1/(1+x)", 4, "1-x+x^2-x^3+x^4"},
+		{"1/(1-x)^2", 3, "1+2*x+3*x^2+4*x^3"},
+		{"(1+x)/(2-x)", 2, "1/2+3/4*x+3/8*x^2"},
+		{"x/(1-x-x^2)", 5, "x+x^2+2*x^3+3*x^4+5*x^5"},
+		{"1/(1-a*x)", 2, "1+a*x+a^2*x^2"},
+		{"x^2+1", 1, "1"},
+		{"3", 0, "3"},
+	}
+	for i, v := range vs {
+		r, _, err := ParseFrac(v.from)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		e, err := r.Series(f.S("x"), v.order)
+		if err != nil {
+			t.Errorf("[%d] series of %q failed: %v", i, v.from, err)
+			continue
+		}
+		if got := e.String(); got != v.want {
+			t.Errorf("[%d] %q to order %d: got=%q want=%q", i, v.from, v.order, got, v.want)
+		}
+	}
+	for i, s := range []string{"1/x", "1/(x+x^2)", "1/(a+x)", "sin(x) / 2"} {
+		r, _, err := ParseFrac(s)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, s, err)
+		}
+		if e, err := r.Series(f.S("x"), 2); err == nil {
+			t.Errorf("[%d] series of %q should fail: got=%v", i, s, e)
+		}
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	vs := []struct {
+		from string
+		want string
+	}{
+		{"x+y", "[]"},
+		{"sqrt(x)", "[sqrt(x)]"},
+		{"sin(t) * cos(t) + sin(t)", "[cos(t) sin(t)]"},
+		{"f(a, b) + f(a) / g(c)", "[f(a) f(a,b) g(c)]"},
+		{"f(b) + f(a)", "[f(a) f(b)]"},
+		{"f_1(x_1)", "[f_1(x_1)]"},
+	}
+	for i, v := range vs {
+		r, _, err := ParseFrac(v.from)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		if got := fmt.Sprint(r.Functions()); got != v.want {
+			t.Errorf("[%d] %q: got=%q want=%q", i, v.from, got, v.want)
+		}
+	}
+	// Unused function tokens are skipped.
+	r, _, _ := ParseFrac("sin(t) + x")
+	r.Num = r.Num.Substitute([]f.Value{f.S("_FN0FN_")}, NewExp())
+	if got := fmt.Sprint(r.Functions()); got != "[]" {
+		t.Errorf("unused function: got=%q", got)
+	}
 }
 
 func TestGgcdLcm(t *testing.T) {
@@ -265,3 +518,2044 @@ func TestGgcdLcm(t *testing.T) {
 		}
 	}
 }
+
+func TestDenominatorBasis(t *testing.T) {
+	vs := []struct {
+		from, want string
+	}{
+		{"a+b", "1"},
+		{"a/b+c/d", "b*d"},
+		{"a/b^2+c/(b*d)", "b^2*d"},
+		{"x/(y+1)", "1"},
+		{"x/(y^2+y)", "y"},
+		{"1/(a+b)", "1"},
+		{"c/(a*c^2+b*c^3)", "c"},
+	}
+	for i, v := range vs {
+		r, _, err := ParseFrac(v.from)
+		if err != nil {
+			t.Errorf("[%d] failed to parse %q: %v", i, v.from, err)
+			continue
+		}
+		basis := r.DenominatorBasis()
+		got := "1"
+		if len(basis) != 0 {
+			got = f.Prod(basis...)
+		}
+		if got != v.want {
+			t.Errorf("[%d] %q -> %v: got=%q, want=%q", i, v.from, r, got, v.want)
+		}
+	}
+}
+
+func TestDegree(t *testing.T) {
+	vs := []struct {
+		from, sym string
+		n         int
+		lead      string
+	}{
+		{"3", "x", 0, "3"},
+		{"a*y+b", "x", 0, "a*y+b"},
+		{"x^2+2*x+1", "x", 2, "1"},
+		{"a*x^3+b*x^3-x+c", "x", 3, "a+b"},
+		{"a*x^-1+b*x^-2", "x", -1, "a"},
+		{"x^2*y+x*y^4", "y", 4, "x"},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		sym := f.S(v.sym)
+		if n := e.Degree(sym); n != v.n {
+			t.Errorf("[%d] %q degree in %s: got=%d, want=%d", i, v.from, v.sym, n, v.n)
+		}
+		if lead := e.LeadingCoeff(sym).String(); lead != v.lead {
+			t.Errorf("[%d] %q leading coefficient in %s: got=%q, want=%q", i, v.from, v.sym, lead, v.lead)
+		}
+	}
+	e, _ := ParseExp("a*x^2+b*x+c*x^2+d")
+	cs := e.Collect(f.S("x"))
+	if len(cs) != 3 {
+		t.Fatalf("collect of %v: %v", e, cs)
+	}
+	for p, want := range map[int]string{2: "a+c", 1: "b", 0: "d"} {
+		if got := cs[p].String(); got != want {
+			t.Errorf("collect x^%d: got=%q, want=%q", p, got, want)
+		}
+	}
+}
+
+func TestPowersOf(t *testing.T) {
+	vs := []struct {
+		from, sym, want string
+	}{
+		{"x^4+3*x^2+1", "x", "[0 2 4]"},
+		{"a*x^3+b*x^3-x", "x", "[1 3]"},
+		{"a*x^-1+b*x^-2+y", "x", "[-2 -1 0]"},
+		{"a+b", "x", "[0]"},
+		{"0", "x", "[]"},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		if got := fmt.Sprint(e.PowersOf(f.S(v.sym))); got != v.want {
+			t.Errorf("[%d] %q powers of %s: got=%s, want=%s", i, v.from, v.sym, got, v.want)
+		}
+	}
+}
+
+func TestReducePowers(t *testing.T) {
+	vs := []struct {
+		from, want string
+		k          int
+	}{
+		{"x^4+x^2+1", "1+y+y^2", 2},
+		{"a*x^6-x^3", "a*y^2-y", 3},
+		{"x^-2+b", "b+y^-1", 2},
+		{"x^4+x^3", "", 0},
+		{"a+b", "", 0},
+		{"x^2+y", "", 0},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		r, k, ok := e.ReducePowers(f.S("x"), "y")
+		if !ok {
+			if v.k != 0 {
+				t.Errorf("[%d] failed to reduce %q", i, v.from)
+			}
+			continue
+		}
+		if got := r.String(); got != v.want || k != v.k {
+			t.Errorf("[%d] %q: got=(%q, %d), want=(%q, %d)", i, v.from, got, k, v.want, v.k)
+		}
+	}
+}
+
+func TestGCD(t *testing.T) {
+	vs := []struct {
+		a, b, want string
+	}{
+		{"x^2-1", "x-1", "-1+x"},
+		{"x-1", "x^2-1", "-1+x"},
+		{"x^2+1", "x-1", "1"},
+		{"x^3-x", "2*x^2+4*x+2", "1+x"},
+		{"3*x^2-3", "6*x+6", "1+x"},
+		{"x^2", "x^5", "x^2"},
+		{"0", "2*x+4", "2+x"},
+	}
+	for i, v := range vs {
+		a, err := ParseExp(v.a)
+		if err != nil {
+			t.Fatalf("[%d] bad a=%q: %v", i, v.a, err)
+		}
+		b, err := ParseExp(v.b)
+		if err != nil {
+			t.Fatalf("[%d] bad b=%q: %v", i, v.b, err)
+		}
+		g, err := GCD(a, b, f.S("x"))
+		if err != nil {
+			t.Errorf("[%d] GCD(%v, %v) failed: %v", i, a, b, err)
+			continue
+		}
+		if got := g.String(); got != v.want {
+			t.Errorf("[%d] GCD(%v, %v): got=%q, want=%q", i, a, b, got, v.want)
+		}
+	}
+	a, _ := ParseExp("x*y-1")
+	b, _ := ParseExp("x-1")
+	if g, err := GCD(a, b, f.S("x")); err == nil {
+		t.Errorf("multivariate GCD(%v, %v) = %v", a, b, g)
+	}
+}
+
+func TestSimplify(t *testing.T) {
+	vs := []struct {
+		from, want string
+	}{
+		{"a+b-a", "b"},
+		{"(a+b)*(a-b)", "a^2-b^2"},
+		{"(x^2-y^2)/(x+y)", "x-y"},
+		{"2*(x+y)*(x-y)/(x^2-y^2)", "2"},
+		{"a/(a+b) + b/(a-b)", "(a^2+b^2)/(a^2-b^2)"},
+		{"(x+1)^2, x-x", "1+2*x+x^2,0"},
+		{"(ct+st)*(ct-st)+2*st^2", "1"},
+		{"(a*ct^2+a*st^2)/(ct^2+st^2+b)", "a/(1+b)"},
+	}
+	for i, v := range vs {
+		got, err := Simplify(v.from)
+		if err != nil {
+			t.Errorf("[%d] failed to simplify %q: %v", i, v.from, err)
+			continue
+		}
+		if got != v.want {
+			t.Errorf("[%d] simplify %q: got=%q, want=%q", i, v.from, got, v.want)
+		}
+	}
+	if got, err := Simplify("(a+b"); err == nil {
+		t.Errorf("simplified bad input to %q", got)
+	}
+}
+
+func TestDerivative(t *testing.T) {
+	vs := []struct {
+		from, sym, want string
+	}{
+		{"3", "x", "0"},
+		{"x^3+3*x^2+a*x+b", "x", "a+6*x+3*x^2"},
+		{"a*x^-2+x*y", "x", "-2*a*x^-3+y"},
+		{"a*x^-2+x*y", "y", "x"},
+		{"x^(3/2)", "x", "3/2*x^(1/2)"},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] bad expression %q: %v", i, v.from, err)
+		}
+		if got := e.Derivative(f.S(v.sym)).String(); got != v.want {
+			t.Errorf("[%d] d(%v)/d%s: got=%q, want=%q", i, e, v.sym, got, v.want)
+		}
+	}
+}
+
+func TestIntegrate(t *testing.T) {
+	vs := []struct {
+		from, sym, want string
+	}{
+		{"3*x^2+2*x", "x", "x^2+x^3"},
+		{"a", "x", "a*x"},
+		{"a*x^-2+x*y", "x", "-a*x^-1+1/2*x^2*y"},
+		{"x^(1/2)", "x", "2/3*x^(3/2)"},
+		{"0", "x", "0"},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] bad expression %q: %v", i, v.from, err)
+		}
+		x := f.S(v.sym)
+		n, err := e.Integrate(x)
+		if err != nil {
+			t.Errorf("[%d] integral of %v failed: %v", i, e, err)
+			continue
+		}
+		if got := n.String(); got != v.want {
+			t.Errorf("[%d] integral of %v d%s: got=%q, want=%q", i, e, v.sym, got, v.want)
+		}
+		if d := n.Derivative(x); !d.Equals(e) {
+			t.Errorf("[%d] d(%v)/d%s=%v, want=%v", i, n, v.sym, d, e)
+		}
+	}
+	e, _ := ParseExp("x+2*x^-1")
+	if n, err := e.Integrate(f.S("x")); err == nil {
+		t.Errorf("integral of %v yielded %v", e, n)
+	}
+}
+
+func TestSquareFree(t *testing.T) {
+	vs := []struct {
+		from string
+		want []string
+	}{
+		{"x^2+2*x+1", []string{"1", "1+x"}},
+		{"2*x^2+4*x+2", []string{"2", "1+x"}},
+		{"x^3-x", []string{"-x+x^3"}},
+		{"x^3+3*x^2-4", []string{"-1+x", "2+x"}},
+		{"x^4-2*x^3+x^2", []string{"1", "-x+x^2"}},
+		{"x^3", []string{"1", "1", "x"}},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] bad expression %q: %v", i, v.from, err)
+		}
+		fs, err := e.SquareFree(f.S("x"))
+		if err != nil {
+			t.Errorf("[%d] square-free %v failed: %v", i, e, err)
+			continue
+		}
+		if got, want := fmt.Sprint(fs), fmt.Sprint(v.want); got != want {
+			t.Errorf("[%d] square-free %v: got=%v, want=%v", i, e, got, want)
+		}
+	}
+	e, _ := ParseExp("x^2*y+1")
+	if fs, err := e.SquareFree(f.S("x")); err == nil {
+		t.Errorf("multivariate %v was factored: %v", e, fs)
+	}
+}
+
+func TestEval(t *testing.T) {
+	vals := map[string]*big.Rat{
+		"a": big.NewRat(2, 1),
+		"b": big.NewRat(-1, 3),
+		"z": big.NewRat(0, 1),
+	}
+	vs := []struct {
+		from, want string
+	}{
+		{"3", "3"},
+		{"a^3*b+a", "-2/3"},
+		{"a^-2+b^-1", "-11/4"},
+		{"z*a+z^2", "0"},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] bad expression %q: %v", i, v.from, err)
+		}
+		r, err := e.Eval(vals)
+		if err != nil {
+			t.Errorf("[%d] eval %v failed: %v", i, e, err)
+			continue
+		}
+		if got := r.RatString(); got != v.want {
+			t.Errorf("[%d] eval %v: got=%q, want=%q", i, e, got, v.want)
+		}
+	}
+	for i, s := range []string{"c+a", "z^-1"} {
+		e, _ := ParseExp(s)
+		if r, err := e.Eval(vals); err == nil {
+			t.Errorf("[%d] eval %v should fail: got=%v", i, e, r)
+		}
+	}
+}
+
+func TestProbablyEquals(t *testing.T) {
+	vs := []struct {
+		a, b string
+		want bool
+	}{
+		{"(a+b)^2", "a^2+2*a*b+b^2", true},
+		{"(a+b)^2", "a^2+b^2", false},
+		{"x/y+1", "(x+y)*y^-1", true},
+		{"3", "3", true},
+		{"3", "x", false},
+		{"x^(1/2)", "x^(1/2)", true},
+		{"x^(1/2)*y^(2/3)+x", "x+y^(2/3)*x^(1/2)", true},
+		{"x^(1/2)*x^(1/3)", "x^(5/6)", true},
+		{"x^(1/2)", "x^(1/3)", false},
+		{"x^(-3/2)", "x^-1", false},
+	}
+	for i, v := range vs {
+		a, _, err := ParseFrac(v.a)
+		if err != nil {
+			t.Fatalf("[%d] bad a=%q: %v", i, v.a, err)
+		}
+		b, _, err := ParseFrac(v.b)
+		if err != nil {
+			t.Fatalf("[%d] bad b=%q: %v", i, v.b, err)
+		}
+		ea, eb := a.Num.Mul(b.Den), b.Num.Mul(a.Den)
+		if got := ea.ProbablyEquals(eb, 5); got != v.want {
+			t.Errorf("[%d] %v =? %v: got=%v, want=%v", i, ea, eb, got, v.want)
+		}
+	}
+}
+
+func TestSplitBy(t *testing.T) {
+	e, err := ParseExp("c1*s2*d0+c1*r0-B00*d1+d2-3")
+	if err != nil {
+		t.Fatalf("bad expression: %v", err)
+	}
+	isTrig := func(sym string) bool {
+		return strings.HasPrefix(sym, "c") || strings.HasPrefix(sym, "s")
+	}
+	v, c := e.SplitBy(isTrig)
+	if got, want := v.String(), "c1*d0*s2+c1*r0"; got != want {
+		t.Errorf("variable part: got=%q, want=%q", got, want)
+	}
+	if got, want := c.String(), "-3-B00*d1+d2"; got != want {
+		t.Errorf("constant part: got=%q, want=%q", got, want)
+	}
+	if !v.Add(c).Equals(e) {
+		t.Errorf("%v + %v != %v", v, c, e)
+	}
+}
+
+func TestSolveLinear(t *testing.T) {
+	vs := []struct {
+		from, want string
+	}{
+		{"2*x+a", "-1/2*a"},
+		{"2*x", "0"},
+		{"a*b*x-b^2+c", "a^-1*b-a^-1*b^-1*c"},
+		{"y^2*x+y-1", "-y^-1+y^-2"},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] bad expression %q: %v", i, v.from, err)
+		}
+		x, err := e.SolveLinear(f.S("x"))
+		if err != nil {
+			t.Errorf("[%d] solving %v failed: %v", i, e, err)
+			continue
+		}
+		if got := x.String(); got != v.want {
+			t.Errorf("[%d] solving %v: got=%q, want=%q", i, e, got, v.want)
+		}
+		if z := e.Substitute([]f.Value{f.S("x")}, x); !z.IsZero() {
+			t.Errorf("[%d] substituted %v: got=%v, want=0", i, e, z)
+		}
+	}
+	for i, s := range []string{"x^2+x", "y+1", "x^-1+2", "a*x+b*x+1"} {
+		e, _ := ParseExp(s)
+		if x, err := e.SolveLinear(f.S("x")); err == nil {
+			t.Errorf("[%d] solving %v should fail: got=%v", i, e, x)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	vs := []struct {
+		from string
+		sign int
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"3/4", 1, true},
+		{"-2", -1, true},
+		{"a-a-5", -1, true},
+		{"a", 0, false},
+		{"a+1", 0, false},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] bad expression %q: %v", i, v.from, err)
+		}
+		if sign, ok := e.Sign(); sign != v.sign || ok != v.ok {
+			t.Errorf("[%d] sign of %v: got=(%d,%v), want=(%d,%v)", i, e, sign, ok, v.sign, v.ok)
+		}
+	}
+}
+
+func TestFactorQuadratic(t *testing.T) {
+	vs := []struct {
+		from, a, b string
+	}{
+		{"x^2-1", "-1+x", "1+x"},
+		{"x^2-3*x+2", "-2+x", "-1+x"},
+		{"4*x^2-1", "-1+2*x", "1+2*x"},
+		{"x^2+2*x+1", "1+x", "1+x"},
+		{"2*x^2-2", "-1+x", "2+2*x"},
+		{"x^2-x", "-1+x", "x"},
+		{"-x^2+1/4", "1+2*x", "1/4-1/2*x"},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] bad expression %q: %v", i, v.from, err)
+		}
+		a, b, ok := e.FactorQuadratic(f.S("x"))
+		if !ok {
+			t.Errorf("[%d] failed to factor %v", i, e)
+			continue
+		}
+		if got := a.String(); got != v.a {
+			t.Errorf("[%d] %v first factor: got=%q want=%q", i, e, got, v.a)
+		}
+		if got := b.String(); got != v.b {
+			t.Errorf("[%d] %v second factor: got=%q want=%q", i, e, got, v.b)
+		}
+		if p := Mul(a, b); !p.Equals(e) {
+			t.Errorf("[%d] %v != %v * %v = %v", i, e, a, b, p)
+		}
+	}
+	for i, s := range []string{"x^2-2", "x^2+1", "x+1", "x^3-x", "a*x^2-1", "y^2-1"} {
+		e, _ := ParseExp(s)
+		if a, b, ok := e.FactorQuadratic(f.S("x")); ok {
+			t.Errorf("[%d] factoring %v should fail: got=%v, %v", i, e, a, b)
+		}
+	}
+}
+
+func TestSolveQuadratic(t *testing.T) {
+	vs := []struct {
+		from string
+		want []string
+	}{
+		{"x^2-3*x+2", []string{"2", "1"}},
+		{"4*x^2-1", []string{"1/2", "-1/2"}},
+		{"x^2+2*x+1", []string{"-1", "-1"}},
+		{"x^2-2", []string{"sqrt(2)", "-sqrt(2)"}},
+		{"x^2-x-1", []string{"(1+sqrt(5))/2", "(1-sqrt(5))/2"}},
+		{"3*x^2-2", []string{"sqrt(6)/3", "-sqrt(6)/3"}},
+		{"x^2+x/2+1/2", []string{"(-1+sqrt(-7))/4", "(-1-sqrt(-7))/4"}},
+		{"a*x^2+b*x+c", []string{"(sqrt(-4*a*c+b^2)-b)/(2*a)", "(-sqrt(-4*a*c+b^2)-b)/(2*a)"}},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] bad expression %q: %v", i, v.from, err)
+		}
+		rs, err := e.SolveQuadratic(f.S("x"))
+		if err != nil {
+			t.Errorf("[%d] solving %v failed: %v", i, e, err)
+			continue
+		}
+		if got, want := fmt.Sprint(rs), fmt.Sprint(v.want); got != want {
+			t.Errorf("[%d] solving %v: got=%v, want=%v", i, e, got, want)
+		}
+	}
+	for i, s := range []string{"x+1", "x^3+x^2", "x^2+x^-1", "y^2"} {
+		e, _ := ParseExp(s)
+		if rs, err := e.SolveQuadratic(f.S("x")); err == nil {
+			t.Errorf("[%d] solving %v should fail: got=%v", i, e, rs)
+		}
+	}
+}
+
+func TestApplyPythagorean(t *testing.T) {
+	vs := []struct {
+		from, want string
+	}{
+		{"st^2+ct^2", "1"},
+		{"3*st^2+3*ct^2", "3"},
+		{"3*st^2+2*ct^2", "2+st^2"},
+		{"-st^2*x-ct^2*x+a", "a-x"},
+		{"st^2+a", "a+st^2"},
+		{"st^2-ct^2", "-ct^2+st^2"},
+		{"st^4+2*st^2*ct^2+ct^4", "1"},
+		{"st^2+ct^2+s2^2", "1+s2^2"},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] bad expression %q: %v", i, v.from, err)
+		}
+		if got := e.ApplyPythagorean("t").String(); got != v.want {
+			t.Errorf("[%d] simplifying %v: got=%q, want=%q", i, e, got, v.want)
+		}
+	}
+}
+
+func TestWeierstrassSubstitute(t *testing.T) {
+	vs := []struct {
+		from, want string
+	}{
+		{"sa", "2*ta/(1+ta^2)"},
+		{"ca", "(1-ta^2)/(1+ta^2)"},
+		{"sa^2+ca^2", "1"},
+		{"x", "x"},
+		{"2*sa*ca", "(4*ta-4*ta^3)/(1+2*ta^2+ta^4)"},
+		{"sb*x+ca", "(1+sb*ta^2*x+sb*x-ta^2)/(1+ta^2)"},
+		{"0", "0"},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] bad expression %q: %v", i, v.from, err)
+		}
+		if got := e.WeierstrassSubstitute("a").String(); got != v.want {
+			t.Errorf("[%d] substituting %v: got=%q, want=%q", i, e, got, v.want)
+		}
+	}
+}
+
+func TestCancelCommon(t *testing.T) {
+	vs := []struct {
+		a, b         string
+		a2, b2, fact string
+	}{
+		{"a*x+a*y", "a^2*z", "x+y", "a*z", "a"},
+		{"-x^2*y", "x*y^3+x^2*y", "x", "-x-y^2", "-x*y"},
+		{"x+y", "z", "x+y", "z", "1"},
+		{"-a*b", "a*c", "b", "-c", "-a"},
+		{"c^-1*x", "c^-2", "c*x", "1", "c^-2"},
+	}
+	for i, v := range vs {
+		a, err := ParseExp(v.a)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.a, err)
+		}
+		b, err := ParseExp(v.b)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.b, err)
+		}
+		a2, b2, fact := CancelCommon(a, b)
+		if got := a2.String(); got != v.a2 {
+			t.Errorf("[%d] a2: got=%q, want=%q", i, got, v.a2)
+		}
+		if got := b2.String(); got != v.b2 {
+			t.Errorf("[%d] b2: got=%q, want=%q", i, got, v.b2)
+		}
+		if got := fact.Exp().String(); got != v.fact {
+			t.Errorf("[%d] factored: got=%q, want=%q", i, got, v.fact)
+		}
+		if !Mul(a2, fact.Exp()).Equals(a) || !Mul(b2, fact.Exp()).Equals(b) {
+			t.Errorf("[%d] factored %v does not rebuild %v and %v", i, fact.Exp(), a, b)
+		}
+	}
+}
+
+func TestTranscript(t *testing.T) {
+	tr := NewTranscript()
+	lhs, _, _ := ParseFrac("2*x+y")
+	rhs, _, _ := ParseFrac("z")
+	left, right, err := tr.Rearrange(lhs, rhs)
+	if err != nil {
+		t.Fatalf("rearrange failed: %v", err)
+	}
+	e, _, _ := ParseFrac("x^2+x")
+	sub := tr.Substitute(e, left.Num.Terms()["x"].Fact, right)
+	tr.Reduce(sub)
+	s, _ := ParseExp("a*x-b")
+	if _, err := tr.Solve(s, f.S("x")); err != nil {
+		t.Fatalf("solve failed: %v", err)
+	}
+	if _, err := tr.Solve(s, f.S("q")); err == nil {
+		t.Fatal("solved for an absent symbol")
+	}
+	lines, err := tr.Replay()
+	if err != nil {
+		t.Fatalf("replay failed: %v", err)
+	}
+	want := []string{
+		"rearrange(2*x+y, z) = x, (-y+z)/2",
+		"substitute(x+x^2, x, (-y+z)/2) = (-2*y-2*y*z+y^2+2*z+z^2)/4",
+		"reduce((-2*y-2*y*z+y^2+2*z+z^2)/4) = (-2*y-2*y*z+y^2+2*z+z^2)/4",
+		"solve(a*x-b, x) = a^-1*b",
+	}
+	if got := strings.Join(lines, "\n"); got != strings.Join(want, "\n") {
+		t.Errorf("replay: got=%q, want=%q", lines, want)
+	}
+	tr.Steps[0].Outputs[1] = "y"
+	if _, err := tr.Replay(); err == nil {
+		t.Error("replay accepted a corrupted transcript")
+	}
+	var none *Transcript
+	if got := none.Reduce(sub).String(); got != sub.String() {
+		t.Errorf("nil transcript reduce: got=%q, want=%q", got, sub.String())
+	}
+}
+
+func TestAsPower(t *testing.T) {
+	vs := []struct {
+		from, base string
+		exp        int
+	}{
+		{"a^2+2*a*b+b^2", "a+b", 2},
+		{"x^3-3*x^2+3*x-1", "-1+x", 3},
+		{"x^4", "x", 4},
+		{"4*x^2*y^6", "2*x*y^3", 2},
+		{"-8*a^3", "-2*a", 3},
+		{"x^6+3*x^4+3*x^2+1", "1+x^2", 3},
+		{"x^2/4-x*y+y^2", "1/2*x-y", 2},
+		{"x^2+1", "", 0},
+		{"-x^2", "", 0},
+		{"4", "", 0},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		base, n, ok := e.AsPower()
+		if !ok {
+			if v.exp != 0 {
+				t.Errorf("[%d] %q not recognized as a power", i, v.from)
+			}
+			continue
+		}
+		if got := base.String(); got != v.base || n != v.exp {
+			t.Errorf("[%d] %q: got=(%q)^%d, want=(%q)^%d", i, v.from, got, n, v.base, v.exp)
+		}
+	}
+}
+
+func TestNewFracFromStrings(t *testing.T) {
+	vs := []struct {
+		num, den, want string
+	}{
+		{"a+b", "c-d", "(a+b)/(c-d)"},
+		{"x^2-1", "x+1", "-1+x"},
+		{"1/a", "1/b", "b/(a)"},
+		{"sin(t)", "cos(t)", "sin(t)/(cos(t))"},
+		{"sin(t)", "sin(t)", "1"},
+		{"2*x", "4", "x/2"},
+	}
+	for i, v := range vs {
+		r, err := NewFracFromStrings(v.num, v.den)
+		if err != nil {
+			t.Errorf("[%d] failed for %q/%q: %v", i, v.num, v.den, err)
+			continue
+		}
+		if got := r.String(); got != v.want {
+			t.Errorf("[%d] %q/%q: got=%q, want=%q", i, v.num, v.den, got, v.want)
+		}
+	}
+	for i, v := range [][2]string{{"x", "0"}, {"x+", "y"}, {"x", "a,b"}} {
+		if r, err := NewFracFromStrings(v[0], v[1]); err == nil {
+			t.Errorf("[%d] %q/%q accepted as %v", i, v[0], v[1], r)
+		}
+	}
+}
+
+func TestDivByZero(t *testing.T) {
+	x := NewExp([]f.Value{f.S("x")})
+	zero := x.Sub(x)
+	if r, err := NewFracChecked(x, zero); err != ErrDivByZero {
+		t.Errorf("x/0 got=%v, %v want ErrDivByZero", r, err)
+	}
+	r, err := NewFracChecked(x, x)
+	if err != nil {
+		t.Fatalf("x/x failed: %v", err)
+	}
+	if err := r.ReduceChecked(); err != nil {
+		t.Errorf("x/x reduce failed: %v", err)
+	} else if got := r.String(); got != "1" {
+		t.Errorf("x/x got=%q want=\"1\"", got)
+	}
+	r = NewFrac(x, zero)
+	if err := r.ReduceChecked(); err != ErrDivByZero {
+		t.Errorf("x/0 reduce got=%v want ErrDivByZero", err)
+	}
+	r.Reduce()
+	if !r.Den.IsZero() || r.Num.String() != "x" {
+		t.Errorf("x/0 reduce modified to %v", r)
+	}
+	if _, err := NewFracFromStrings("x", "y-y"); !errors.Is(err, ErrDivByZero) {
+		t.Errorf("NewFracFromStrings got=%v want ErrDivByZero", err)
+	}
+	lhs := NewFrac(x)
+	rhs := NewFrac(NewExp([]f.Value{f.S("y")}), zero)
+	if left, right, err := Rearrange(lhs, rhs); err != ErrDivByZero {
+		t.Errorf("Rearrange got=%v=%v, %v want ErrDivByZero", left, right, err)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	vs := []struct {
+		from, want string
+	}{
+		{"(a+b)*(a-b)", "a^2-b^2"},
+		{"-(x+y)", "-x-y"},
+		{"(x+1)^3", "1+3*x+3*x^2+x^3"},
+		{"((a+b)*c+1)*2", "2+2*a*c+2*b*c"},
+		{"(x^2-1)/(x-1)", "1+x"},
+		{"a*b", "a*b"},
+	}
+	for i, v := range vs {
+		e, err := Expand(v.from)
+		if err != nil {
+			t.Errorf("[%d] failed to expand %q: %v", i, v.from, err)
+			continue
+		}
+		if got := e.String(); got != v.want {
+			t.Errorf("[%d] %q: got=%q, want=%q", i, v.from, got, v.want)
+		}
+	}
+	for i, s := range []string{"(a+b)/(a-b)", "1/x", "(a+", "a,b"} {
+		if e, err := Expand(s); err == nil {
+			t.Errorf("[%d] %q expanded to %v", i, s, e)
+		}
+	}
+}
+
+func TestLeading(t *testing.T) {
+	vs := []struct {
+		from, want string
+	}{
+		{"a*b+b*c+a*c", "a*b"},
+		{"c*b+c*a", "a*c"},
+		{"x^2+a*b+y^2", "a*b"},
+		{"-y^2+2*x^2", "2*x^2"},
+		{"a^3+b^2*c+a*b*c+z", "a*b*c"},
+		{"x+y^2+1", "y^2"},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		ts := e.sortedTerms()
+		want, err := e.Leading()
+		if err != nil {
+			t.Fatalf("[%d] no leading term for %v: %v", i, e, err)
+		}
+		if got := NewExp(append([]f.Value{f.R(want.Coeff)}, want.Fact...)).String(); got != v.want {
+			t.Errorf("[%d] leading term of %v: got=%q want=%q", i, e, got, v.want)
+		}
+		// The choice must not depend on the order of the terms.
+		for j := range ts {
+			rot := append(append([]Term(nil), ts[j:]...), ts[:j]...)
+			for _, order := range [][]Term{rot, reversed(rot)} {
+				got, err := HighestOrder(order)
+				if err != nil {
+					t.Fatalf("[%d,%d] no leading term: %v", i, j, err)
+				}
+				if f.Prod(got.Fact...) != f.Prod(want.Fact...) {
+					t.Errorf("[%d,%d] leading term of %v: got=%v want=%v", i, j, order, got, want)
+				}
+			}
+		}
+	}
+	if _, err := NewExp(one).Leading(); err != f.ErrDone {
+		t.Errorf("constant leading term: got=%v, want=%v", err, f.ErrDone)
+	}
+}
+
+// reversed returns a reversed copy of ts.
+func reversed(ts []Term) []Term {
+	var rs []Term
+	for i := len(ts) - 1; i >= 0; i-- {
+		rs = append(rs, ts[i])
+	}
+	return rs
+}
+
+func TestLeadSelector(t *testing.T) {
+	// Prefer the first term containing a symbol starting with "s".
+	sinFirst := func(ts []Term) (Term, error) {
+		for _, x := range ts {
+			for _, v := range x.Fact {
+				if strings.HasPrefix(v.Symbol(), "s") {
+					return x, nil
+				}
+			}
+		}
+		return HighestOrder(ts)
+	}
+	lhs, _, _ := ParseFrac("2*c1*c2+3*s1")
+	rhs, _, _ := ParseFrac("r")
+	l, r, err := Rearrange(lhs, rhs)
+	if err != nil {
+		t.Fatalf("rearrange failed: %v", err)
+	}
+	if got, want := fmt.Sprint(l, " = ", r), "c1*c2 = (r-3*s1)/2"; got != want {
+		t.Errorf("rearrange: got=%q, want=%q", got, want)
+	}
+	l, r, err = RearrangeWith(lhs, rhs, sinFirst)
+	if err != nil {
+		t.Fatalf("rearrange with selector failed: %v", err)
+	}
+	if got, want := fmt.Sprint(l, " = ", r), "s1 = (-2*c1*c2+r)/3"; got != want {
+		t.Errorf("rearrange with selector: got=%q, want=%q", got, want)
+	}
+
+	e, _ := ParseExp("x^2+s^2")
+	a, _ := ParseExp("x^2+s")
+	for i, sel := range []LeadSelector{HighestOrder, sinFirst} {
+		div, rem, err := e.DivideWith(a, sel)
+		if err != nil {
+			t.Fatalf("[%d] divide with selector failed: %v", i, err)
+		}
+		if got := Mul(div, a).Add(rem); !got.Equals(e) {
+			t.Errorf("[%d] divide: %v*%v+%v = %v, want=%v", i, div, a, rem, got, e)
+		}
+		if got, want := fmt.Sprint(div, "; ", rem), []string{"1; -s+s^2", "s-x^2; x^2+x^4"}[i]; got != want {
+			t.Errorf("[%d] divide with selector: got=%q, want=%q", i, got, want)
+		}
+	}
+	if _, err := HighestOrder(nil); err != f.ErrDone {
+		t.Errorf("empty selection: got=%v, want=%v", err, f.ErrDone)
+	}
+}
+
+func TestDivideCtx(t *testing.T) {
+	e, _ := ParseExp("x^3+2*x+y")
+	a, _ := ParseExp("x+1")
+	div, rem, err := e.Divide(a)
+	if err != nil {
+		t.Fatalf("divide failed: %v", err)
+	}
+	cdiv, crem, err := e.DivideCtx(context.Background(), a)
+	if err != nil {
+		t.Fatalf("divide with context failed: %v", err)
+	}
+	if got, want := fmt.Sprint(cdiv, "; ", crem), fmt.Sprint(div, "; ", rem); got != want {
+		t.Errorf("divide with context: got=%q, want=%q", got, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := e.DivideCtx(ctx, a); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled divide: got=%v, want=%v", err, context.Canceled)
+	}
+
+	// Each step of this division only removes one power of x.
+	slow, _ := ParseExp("x^400")
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, err := slow.DivideCtx(ctx, a); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("slow divide: got=%v, want=%v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("slow divide took %v to stop", d)
+	}
+}
+
+func TestPow(t *testing.T) {
+	vs := []struct {
+		from string
+		n    int
+		want string
+	}{
+		{"x+1", 3, "1+3*x+3*x^2+x^3"},
+		{"a-b", 2, "-2*a*b+a^2+b^2"},
+		{"2*x", 0, "1"},
+		{"0", 2, "0"},
+		{"x^-1+y", 1, "x^-1+y"},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		p, err := e.Pow(v.n)
+		if err != nil {
+			t.Errorf("[%d] (%s)^%d failed: %v", i, v.from, v.n, err)
+			continue
+		}
+		if got := p.String(); got != v.want {
+			t.Errorf("[%d] (%s)^%d: got=%q, want=%q", i, v.from, v.n, got, v.want)
+		}
+	}
+	e, _ := ParseExp("x+1")
+	if p, err := e.Pow(-1); err == nil {
+		t.Errorf("negative power accepted: %v", p)
+	}
+}
+
+func TestProportional(t *testing.T) {
+	vs := []struct {
+		a, b, k string
+	}{
+		{"a+b", "b+a", "1"},
+		{"2*x-4*y", "-x+2*y", "-2"},
+		{"x^2/3", "x^2", "1/3"},
+		{"a*b-c", "3*a*b-3*c", "1/3"},
+		{"a+b", "a-b", ""},
+		{"a+b", "a+c", ""},
+		{"a+b", "a", ""},
+		{"0", "a", ""},
+		{"a", "0", ""},
+	}
+	for i, v := range vs {
+		a, err := ParseExp(v.a)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.a, err)
+		}
+		b, err := ParseExp(v.b)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.b, err)
+		}
+		k, ok := a.Proportional(b)
+		if v.k == "" {
+			if ok {
+				t.Errorf("[%d] %v and %v are not proportional: got k=%v", i, a, b, k)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("[%d] %v and %v should be proportional", i, a, b)
+		} else if got := k.RatString(); got != v.k {
+			t.Errorf("[%d] %v / %v: got=%q want=%q", i, a, b, got, v.k)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	vs := []struct {
+		a, b string
+	}{
+		{"a+b", "b+a"},
+		{"a^2+2*a*b+b^2", "b^2+2*b*a+a*a"},
+		{"x*y*z-1", "-1+z*y*x"},
+		{"a-a", "0"},
+		{"2*x/4", "x/2"},
+	}
+	for i, v := range vs {
+		a, err := ParseExp(v.a)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.a, err)
+		}
+		b, err := ParseExp(v.b)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.b, err)
+		}
+		if ha, hb := a.Hash(), b.Hash(); ha != hb {
+			t.Errorf("[%d] %q and %q hash differently: %x != %x", i, v.a, v.b, ha, hb)
+		}
+	}
+	seen := make(map[uint64]string)
+	for _, s := range []string{"0", "1", "-1", "x", "2*x", "x^2", "x+y", "x-y", "x*y", "1/2*x"} {
+		e, err := ParseExp(s)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", s, err)
+		}
+		h := e.Hash()
+		if old, ok := seen[h]; ok {
+			t.Errorf("%q and %q share a hash: %x", old, s, h)
+		}
+		seen[h] = s
+	}
+}
+
+func TestNumericRoots(t *testing.T) {
+	vs := []struct {
+		from, want string
+	}{
+		{"4^(1/2)", "2"},
+		{"8^(1/3)", "2"},
+		{"8^(2/3)*x", "4*x"},
+		{"4^(-1/2)", "1/2"},
+		{"2^(1/2)", "sqrt(2)"},
+		{"x*2^(3/2)", "2*sqrt(2)*x"},
+		{"a+2^(1/2)*2^(1/2)", "2+a"},
+		{"2^(1/2)*3^(1/2)*2^(1/2)", "2*sqrt(3)"},
+		{"x/2^(1/2)^3", "x/(2*sqrt(2))"},
+		{"3^(4/3)", "3*root(3,3)"},
+		{"sqrt(a)^2", "sqrt(a)^2"},
+		{"3^(2/6)", "root(3,3)"},
+		{"x2^(1/2)", "x2^(1/2)"},
+	}
+	for i, v := range vs {
+		r, _, err := ParseFrac(v.from)
+		if err != nil {
+			t.Errorf("[%d] failed to parse %q: %v", i, v.from, err)
+			continue
+		}
+		if got := r.String(); got != v.want {
+			t.Errorf("[%d] %q: got=%q want=%q", i, v.from, got, v.want)
+		}
+	}
+}
+
+func TestEvalFloat(t *testing.T) {
+	bindings := map[string]float64{"x": 0.5, "y": 2, "t": math.Pi / 6}
+	vs := []struct {
+		from string
+		want float64
+	}{
+		{"x^2+y", 2.25},
+		{"x/y^2", 0.125},
+		{"sin(t)", 0.5},
+		{"cos(t)/2", math.Sqrt(3) / 4},
+		{"sqrt(y^2*x+2)", 2},
+		{"log(exp(y))", 2},
+		{"tan(t) / sin(t)", 1 / math.Cos(math.Pi/6)},
+		{"x + cos(t)^2", 1.25},
+		{"y^(3/2)*x", math.Sqrt(2)},
+		{"3^(1/3)", math.Cbrt(3)},
+	}
+	for i, v := range vs {
+		r, _, err := ParseFrac(v.from)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		got, err := r.EvalFloat(bindings)
+		if err != nil {
+			t.Errorf("[%d] %q failed: %v", i, v.from, err)
+			continue
+		}
+		if math.Abs(got-v.want) > 1e-12 {
+			t.Errorf("[%d] %q: got=%g, want=%g", i, v.from, got, v.want)
+		}
+	}
+	for i, s := range []string{"z+x", "foo(x)", "x/(y-2)"} {
+		r, _, err := ParseFrac(s)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, s, err)
+		}
+		if got, err := r.EvalFloat(bindings); err == nil {
+			t.Errorf("[%d] %q evaluated to %g", i, s, got)
+		}
+	}
+}
+
+func TestComplexParts(t *testing.T) {
+	vs := []struct {
+		from, re, im, conj string
+	}{
+		{"a+b*i", "a", "b", "a-b*i"},
+		{"3*i^2+x*i^3", "-3", "-x", "-3+i*x"},
+		{"i^4+i^-1", "1", "-1", "1+i"},
+		{"x^2+y", "x^2+y", "0", "x^2+y"},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		if got := e.Real().String(); got != v.re {
+			t.Errorf("[%d] real part of %q: got=%q, want=%q", i, v.from, got, v.re)
+		}
+		if got := e.Imag().String(); got != v.im {
+			t.Errorf("[%d] imaginary part of %q: got=%q, want=%q", i, v.from, got, v.im)
+		}
+		if got := e.Conjugate().String(); got != v.conj {
+			t.Errorf("[%d] conjugate of %q: got=%q, want=%q", i, v.from, got, v.conj)
+		}
+	}
+	// |z|^2 = z*conj(z).
+	z, _ := ParseExp("a+b*i")
+	if got, want := Mul(z, z.Conjugate()).String(), "a^2+b^2"; got != want {
+		t.Errorf("|z|^2: got=%q, want=%q", got, want)
+	}
+	if z.IsReal() || !Mul(z, z.Conjugate()).IsReal() {
+		t.Errorf("bad IsReal for %v", z)
+	}
+	if got, err := Simplify("(1+i)*(1-i)"); err != nil || got != "2" {
+		t.Errorf("(1+i)*(1-i): got=%q, %v want=\"2\"", got, err)
+	}
+}
+
+func TestToIntegerCoeffs(t *testing.T) {
+	vs := []struct {
+		from, want, scale string
+	}{
+		{"x/2+y/3", "3*x+2*y", "6"},
+		{"4*x^2-6*y", "2*x^2-3*y", "1/2"},
+		{"-x^2/3+2/9", "-2+3*x^2", "-9"},
+		{"5", "1", "1/5"},
+		{"0", "0", "1"},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		r, scale := e.ToIntegerCoeffs()
+		if got := r.String(); got != v.want || scale.RatString() != v.scale {
+			t.Errorf("[%d] %q: got=(%q, %s), want=(%q, %s)", i, v.from, got, scale.RatString(), v.want, v.scale)
+		}
+	}
+}
+
+func TestLaTeX(t *testing.T) {
+	vs := []struct {
+		from, want string
+	}{
+		{"a*b^-1", `\frac{a}{b}`},
+		{"3*x^-2-1", `\frac{3-x^{2}}{x^{2}}`},
+		{"(a+b)/(c-d)", `\frac{a+b}{c-d}`},
+		{"-a/(c+d)", `-\frac{a}{c+d}`},
+		{"sqrt(x^2+1) / 2", `\frac{\sqrt(1+x^{2})}{2}`},
+		{"0", "0"},
+	}
+	for i, v := range vs {
+		r, _, err := ParseFrac(v.from)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		if got := r.LaTeX(); got != v.want {
+			t.Errorf("[%d] %q: got=%q, want=%q", i, v.from, got, v.want)
+		}
+	}
+	for i, v := range []struct {
+		from, want string
+	}{
+		{"2*a*b^-1-c^3", `\frac{2 a}{b}-c^{3}`},
+		{"-a/2+x^2*y", `-\frac{a}{2}+x^{2} y`},
+		{"3*x^-2-1", `-1+\frac{3}{x^{2}}`},
+	} {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		if got := e.LaTeX(); got != v.want {
+			t.Errorf("[%d] exp %q: got=%q, want=%q", i, v.from, got, v.want)
+		}
+	}
+}
+
+func TestStringOrdered(t *testing.T) {
+	vs := []struct {
+		from, want string
+	}{
+		{"1+2*x+x^2", "x^2+2*x+1"},
+		{"a^2+a^10+3", "a^10+a^2+3"},
+		{"b^2-2*a*b+a^2", "a^2-2*a*b+b^2"},
+		{"x*y^2+x^3-y+7*x", "x^3+x*y^2+7*x-y"},
+		{"y^(1/2)+x^(1/2)+x", "x+x^(1/2)+y^(1/2)"},
+		{"x^(1/3)+x^(1/2)+x^(2/3)*y^(1/3)", "x^(2/3)*y^(1/3)+x^(1/2)+x^(1/3)"},
+		{"-1", "-1"},
+		{"0", "0"},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		if got := e.StringOrdered(); got != v.want {
+			t.Errorf("[%d] %q: got=%q, want=%q", i, v.from, got, v.want)
+		}
+	}
+}
+
+func TestIterate(t *testing.T) {
+	e, _ := ParseExp("3*x^2*y-x/2+5")
+	var got []string
+	for _, x := range e.Iterate() {
+		got = append(got, fmt.Sprint(x.Coeff.RatString(), " ", x.Factors))
+		x.Coeff.SetInt64(7)
+		if len(x.Factors) != 0 {
+			x.Factors[0] = f.S("z")
+		}
+	}
+	if want := "5 []; -1/2 [x]; 3 [x^2 y]"; strings.Join(got, "; ") != want {
+		t.Errorf("iterate: got=%q, want=%q", strings.Join(got, "; "), want)
+	}
+	if got, want := e.String(), "5-1/2*x+3*x^2*y"; got != want {
+		t.Errorf("iterate modified expression: got=%q, want=%q", got, want)
+	}
+	if ts := NewExp().Iterate(); len(ts) != 0 {
+		t.Errorf("zero has terms: %v", ts)
+	}
+}
+
+func TestRationalPowers(t *testing.T) {
+	vs := []struct {
+		from, want string
+	}{
+		{"x^(1/2)*x^(1/2)", "x"},
+		{"(a+b)*a^(1/2)", "a^(1/2)*b+a^(3/2)"},
+		{"(x^(1/2)+1)*(x^(1/2)-1)", "-1+x"},
+		{"y^(2/3)/y", "1/(y^(1/3))"},
+	}
+	for i, v := range vs {
+		got, err := Simplify(v.from)
+		if err != nil {
+			t.Errorf("[%d] failed to simplify %q: %v", i, v.from, err)
+			continue
+		}
+		if got != v.want {
+			t.Errorf("[%d] %q: got=%q, want=%q", i, v.from, got, v.want)
+		}
+	}
+	e, _ := ParseExp("x^(3/2)+y^(1/3)")
+	if r, err := e.Eval(map[string]*big.Rat{"x": big.NewRat(4, 1), "y": big.NewRat(-8, 27)}); err != nil {
+		t.Errorf("failed to evaluate %v: %v", e, err)
+	} else if got, want := r.RatString(), "22/3"; got != want {
+		t.Errorf("eval: got=%q, want=%q", got, want)
+	}
+	if r, err := e.Eval(map[string]*big.Rat{"x": big.NewRat(2, 1), "y": big.NewRat(1, 1)}); err == nil {
+		t.Errorf("irrational value %v evaluated to %v", e, r)
+	}
+	for i, s := range []string{"(x+1)^(1/2)", "a*(x+y)**(2/3)", "(x)^(-1/2)"} {
+		if r, _, err := ParseFrac(s); !errors.Is(err, f.ErrSyntax) {
+			t.Errorf("[%d] %q: got=%v, %v want syntax error", i, s, r, err)
+		}
+		if r, err := Expand(s); err == nil {
+			t.Errorf("[%d] %q: expanded to %v", i, s, r)
+		}
+	}
+}
+
+func TestFactor(t *testing.T) {
+	vs := []struct {
+		e, fact, rest string
+	}{
+		{"2*a*x+4*a*y", "2*a", "x+2*y"},
+		{"x+y", "1", "x+y"},
+		{"1/2*x^2-3/4*x", "1/4*x", "-3+2*x"},
+		{"-6*b^-1*c", "-6*b^-1*c", "1"},
+		{"0", "1", "0"},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.e)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.e, err)
+		}
+		fact, rest := e.Factor()
+		if got := fact.Exp().String(); got != v.fact {
+			t.Errorf("[%d] factor: got=%q, want=%q", i, got, v.fact)
+		}
+		if got := rest.String(); got != v.rest {
+			t.Errorf("[%d] rest: got=%q, want=%q", i, got, v.rest)
+		}
+		if !Mul(fact.Exp(), rest).Equals(e) {
+			t.Errorf("[%d] %v * (%v) != %v", i, fact.Exp(), rest, e)
+		}
+	}
+}
+
+func TestFracEquals(t *testing.T) {
+	vs := []struct {
+		a, b string
+		eq   bool
+	}{
+		{"x", "x", true},
+		{"x/y", "2*x/(2*y)", true},
+		{"(x^2-1)/(x-1)", "x+1", true},
+		{"a/(a+b) + b/(a-b)", "(a^2+b^2)/(a^2-b^2)", true},
+		{"x/y", "y/x", false},
+		{"sin(t)/c", "2 * sin(t)/(2*c)", true},
+		{"sin(t)/c", "cos(t)/c", false},
+		{"0", "0/x", true},
+	}
+	for i, v := range vs {
+		a, _, err := ParseFrac(v.a)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.a, err)
+		}
+		b, _, err := ParseFrac(v.b)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.b, err)
+		}
+		before := b.String()
+		if got := a.Equals(b); got != v.eq {
+			t.Errorf("[%d] %q == %q: got=%v, want=%v", i, v.a, v.b, got, v.eq)
+		}
+		if got := b.Equals(a); got != v.eq {
+			t.Errorf("[%d] %q == %q: got=%v, want=%v", i, v.b, v.a, got, v.eq)
+		}
+		if after := b.String(); after != before {
+			t.Errorf("[%d] b modified: got=%q, want=%q", i, after, before)
+		}
+	}
+	if !NewFrac().Equals(&Frac{}) {
+		t.Error("0/1 != nil/nil")
+	}
+}
+
+func TestIsReduced(t *testing.T) {
+	vs := []struct {
+		num, den string
+		reduced  bool
+		want     string
+	}{
+		{"x^2-1", "x-1", false, "1+x"},
+		{"x^2-1", "x^2+2*x+1", false, "(-1+x)/(1+x)"},
+		{"a*x", "a*y", false, "x/(y)"},
+		{"x+1", "x-1", true, "(1+x)/(-1+x)"},
+		{"x*y-1", "x-1", true, "(-1+x*y)/(-1+x)"},
+	}
+	for i, v := range vs {
+		num, err := ParseExp(v.num)
+		if err != nil {
+			t.Fatalf("[%d] bad num=%q: %v", i, v.num, err)
+		}
+		den, err := ParseExp(v.den)
+		if err != nil {
+			t.Fatalf("[%d] bad den=%q: %v", i, v.den, err)
+		}
+		f := &Frac{Num: num, Den: den}
+		if got := f.IsReduced(); got != v.reduced {
+			t.Errorf("[%d] (%v)/(%v) reduced: got=%v, want=%v", i, num, den, got, v.reduced)
+		}
+		f.Reduce()
+		if !f.IsReduced() {
+			t.Errorf("[%d] %v not reduced by Reduce", i, f)
+		}
+		if got := f.String(); got != v.want {
+			t.Errorf("[%d] got=%q, want=%q", i, got, v.want)
+		}
+	}
+}
+
+func benchmarkReduce(b *testing.B, num, den *Exp) {
+	for i := 0; i < b.N; i++ {
+		r := &Frac{Num: num, Den: den}
+		r.Reduce()
+	}
+}
+
+func BenchmarkReduceMonomial(b *testing.B) {
+	var es []*Exp
+	for i := 1; i <= 50; i++ {
+		es = append(es, NewExp([]f.Value{f.D(int64(i), 1), f.Sp("x", i), f.Sp("y", i%7)}))
+	}
+	d, _ := ParseExp("3*x^2*z")
+	benchmarkReduce(b, Sum(es...), d)
+}
+
+func BenchmarkReducePolynomial(b *testing.B) {
+	x1, _ := ParseExp("x+1")
+	xy, _ := ParseExp("x-y")
+	benchmarkReduce(b, Mul(x1, x1, x1, xy), Mul(x1, x1, x1).Sub(Mul(xy, xy)))
+}
+
+func TestReduce(t *testing.T) {
+	rule := func(from, to string) Rule {
+		f, err := ParseExp(from)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", from, err)
+		}
+		e, err := ParseExp(to)
+		if err != nil {
+			t.Fatalf("failed to parse %q: %v", to, err)
+		}
+		return Rule{From: f.terms[f.String()].Fact, To: e}
+	}
+	vs := []struct {
+		from  string
+		rules []Rule
+		want  string
+		fixed bool
+	}{
+		{"x^2", []Rule{rule("y", "2"), rule("x", "y+1")}, "9", true},
+		{"a*b+a", []Rule{rule("a", "d"), rule("a*b", "c")}, "c+d", true},
+		{"z", []Rule{rule("x", "y")}, "z", true},
+		{"x", []Rule{rule("x", "y"), rule("y", "x")}, "x", false},
+		{"sa^2+x", []Rule{rule("sa^2", "1-ca^2")}, "1-ca^2+x", true},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		got, fixed := Reduce(e, v.rules)
+		if s := got.String(); s != v.want || fixed != v.fixed {
+			t.Errorf("[%d] reducing %v: got=%q,%v want=%q,%v", i, e, s, fixed, v.want, v.fixed)
+		}
+	}
+}
+
+func TestSubstituteFn(t *testing.T) {
+	vs := []struct {
+		from, name string
+		params     []string
+		body, want string
+	}{
+		{"2 * f(a) + f(b)^2", "f", []string{"x"}, "x^2+1", "3+2*a^2+2*b^2+b^4"},
+		{"st+sigma", "s", []string{"x"}, "x^2", "sigma+st"},
+		{"st + s(t)", "s", []string{"x"}, "x^2", "st+t^2"},
+		{"f(x) + 1", "f", []string{"x"}, "x^2", "1+x^2"},
+		{"g(y,x)", "g", []string{"x", "y"}, "x-2*y", "-2*x+y"},
+		{"f(x+1)*2", "f", []string{"y"}, "y^-1+y", "(4+4*x+2*x^2)/(1+x)"},
+		{"f(a) + g(a)", "f", []string{"x"}, "x", "g(a)+a"},
+		{"f(a,b)", "f", []string{"x"}, "x", "f(a,b)"},
+	}
+	for i, v := range vs {
+		r, args, err := ParseFrac(v.from)
+		if err != nil || args != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		body, err := ParseExp(v.body)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.body, err)
+		}
+		var params []f.Value
+		for _, p := range v.params {
+			params = append(params, f.S(p))
+		}
+		if got := r.SubstituteFn(v.name, params, body).String(); got != v.want {
+			t.Errorf("[%d] got=%q, want=%q", i, got, v.want)
+		}
+	}
+}
+
+func TestFreeSymbols(t *testing.T) {
+	known := map[string]bool{"a": true, "x": true}
+	vs := []struct {
+		from, want string
+	}{
+		{"a+x", "[]"},
+		{"a*r0+B12^2-x/y", "[B12 r0 y]"},
+		{"sqrt(x) + z", "[z]"},
+		{"3", "[]"},
+	}
+	for i, v := range vs {
+		r, _, err := ParseFrac(v.from)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		if got := fmt.Sprint(r.Num.Add(r.Den).FreeSymbols(known)); got != v.want {
+			t.Errorf("[%d] %q: got=%q want=%q", i, v.from, got, v.want)
+		}
+	}
+	e, _ := ParseExp("a*b")
+	if got := fmt.Sprint(e.FreeSymbols(nil)); got != "[a b]" {
+		t.Errorf("no known symbols: got=%q", got)
+	}
+}
+
+func TestFracSymbols(t *testing.T) {
+	vs := []struct {
+		from, syms, all string
+	}{
+		{"a/b", "[a b]", "[a b]"},
+		{"(x+y)/(x-z)", "[x y z]", "[x y z]"},
+		{"sqrt(r) * a / b", "[a b]", "[a b r]"},
+		{"f(g(u)/v, a)", "[]", "[a u v]"},
+		{"2", "[]", "[]"},
+	}
+	for i, v := range vs {
+		r, _, err := ParseFrac(v.from)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		if got := fmt.Sprint(r.Symbols()); got != v.syms {
+			t.Errorf("[%d] %q symbols: got=%q want=%q", i, v.from, got, v.syms)
+		}
+		if got := fmt.Sprint(r.AllSymbols()); got != v.all {
+			t.Errorf("[%d] %q all symbols: got=%q want=%q", i, v.from, got, v.all)
+		}
+	}
+	r, _, _ := ParseFrac("sqrt(r) * a / b")
+	if got := fmt.Sprint(r.FreeSymbols(map[string]bool{"a": true, "r": true})); got != "[b]" {
+		t.Errorf("free symbols: got=%q want=\"[b]\"", got)
+	}
+}
+
+func TestRename(t *testing.T) {
+	vs := []struct {
+		e       string
+		aliases map[string]string
+		want    string
+	}{
+		{"c1*s1+c1^2", map[string]string{"c1": "theta"}, "s1*theta+theta^2"},
+		{"a*b^-2+b", map[string]string{"a": "b", "b": "a"}, "a+a^-2*b"},
+		{"a+b", map[string]string{"a": "b"}, "a+b"},
+		{"a+b+c", map[string]string{"a": "d", "b": "d"}, "a+b+c"},
+		{"x^(1/2)", map[string]string{"x": "y"}, "y^(1/2)"},
+		{"x*y", map[string]string{"x": "2z", "y": "z"}, "x*z"},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.e)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.e, err)
+		}
+		if got := e.Rename(v.aliases).String(); got != v.want {
+			t.Errorf("[%d] got=%q, want=%q", i, got, v.want)
+		}
+		if got := e.String(); got != v.e {
+			t.Errorf("[%d] modified: got=%q, want=%q", i, got, v.e)
+		}
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	from := []string{"0", "x^(1/2)*y^(-1/3)", "alpha^-2*beta_1", "i^3-1/3"}
+	for _, v := range parseExpTests {
+		from = append(from, v.from)
+	}
+	for i, s := range from {
+		e, err := ParseExp(s)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, s, err)
+		}
+		m := e.Marshal()
+		if m != e.String() {
+			t.Errorf("[%d] marshal of %q: got=%q, want=%q", i, s, m, e.String())
+		}
+		u, err := Unmarshal(m)
+		if err != nil {
+			t.Errorf("[%d] failed to unmarshal %q: %v", i, m, err)
+			continue
+		}
+		if !u.Equals(e) {
+			t.Errorf("[%d] round trip of %q: got=%v, want=%v", i, s, u, e)
+		}
+		if got := u.Marshal(); got != m {
+			t.Errorf("[%d] not canonical: got=%q, want=%q", i, got, m)
+		}
+	}
+	if got := NewExp().Marshal(); got != "0" {
+		t.Errorf("zero: got=%q, want=\"0\"", got)
+	}
+}
+
+func TestSumFrac(t *testing.T) {
+	vs := []struct {
+		fs   []string
+		want string
+	}{
+		{[]string{"a/b", "c/d"}, "(a*d+b*c)/(b*d)"},
+		{[]string{"1/(x-1)", "1/(x^2-1)"}, "(2+x)/(-1+x^2)"},
+		{[]string{"x/(x+1)", "1/(x+1)"}, "1"},
+		{[]string{"1/a", "1/b", "1/c"}, "(a*b+a*c+b*c)/(a*b*c)"},
+		{[]string{"sin(t)/c", "cos(t)/c"}, "(sin(t)+cos(t))/(c)"},
+	}
+	for i, v := range vs {
+		var fs []*Frac
+		for _, s := range v.fs {
+			f, _, err := ParseFrac(s)
+			if err != nil {
+				t.Fatalf("[%d] failed to parse %q: %v", i, s, err)
+			}
+			fs = append(fs, f)
+		}
+		if got := SumFrac(fs...).String(); got != v.want {
+			t.Errorf("[%d] got=%q, want=%q", i, got, v.want)
+		}
+	}
+	if got := SumFrac().String(); got != "0" {
+		t.Errorf("empty sum: got=%q, want=\"0\"", got)
+	}
+	a, _, _ := ParseFrac("a/(a+b)")
+	b := SumFrac(a)
+	if b == a || b.String() != a.String() {
+		t.Errorf("single sum: got=%v, want a copy of %v", b, a)
+	}
+	a, _, _ = ParseFrac("sin(t+1)/c")
+	was := a.String()
+	b = SumFrac(a)
+	if b.Den == a.Den {
+		t.Errorf("single sum shares its denominator with %v", a)
+	}
+	for tok, fn := range b.Fns {
+		fn.Args[0].Num = NewExp([]f.Value{f.S("u")})
+		b.Fns[tok] = FnDef{Name: "cos", Args: fn.Args}
+	}
+	if got := a.String(); got != was {
+		t.Errorf("changing the single sum changed its input: got=%q, want=%q", got, was)
+	}
+}
+
+func TestFracArithmetic(t *testing.T) {
+	vs := []struct {
+		a, b                string
+		mul, div, pow2, inv string
+	}{
+		{"a/b", "b/c", "a/(c)", "a*c/(b^2)", "a^2/(b^2)", "b/(a)"},
+		{"(x^2-1)/x", "x/(x+1)", "-1+x", "(-1-x+x^2+x^3)/(x^2)", "(1-2*x^2+x^4)/(x^2)", "x/(-1+x^2)"},
+		{"sin(t)/c", "c / cos(t)", "sin(t)/(cos(t))", "sin(t)*cos(t)/(c^2)", "sin(t)^2/(c^2)", "c/(sin(t))"},
+	}
+	for i, v := range vs {
+		a, _, err := ParseFrac(v.a)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.a, err)
+		}
+		b, _, err := ParseFrac(v.b)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.b, err)
+		}
+		if got := a.Mul(b).String(); got != v.mul {
+			t.Errorf("[%d] mul: got=%q, want=%q", i, got, v.mul)
+		}
+		if got := a.Div(b).String(); got != v.div {
+			t.Errorf("[%d] div: got=%q, want=%q", i, got, v.div)
+		}
+		if got := a.Pow(2).String(); got != v.pow2 {
+			t.Errorf("[%d] pow2: got=%q, want=%q", i, got, v.pow2)
+		}
+		if got := a.Pow(-1).String(); got != v.inv {
+			t.Errorf("[%d] inv: got=%q, want=%q", i, got, v.inv)
+		}
+		if got := a.Pow(0).String(); got != "1" {
+			t.Errorf("[%d] pow0: got=%q, want=\"1\"", i, got)
+		}
+	}
+}
+
+func TestSortedTerms(t *testing.T) {
+	e, err := ParseExp("x^3-2*a*b+a^2+3+b")
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	if got, want := e.Len(), 5; got != want {
+		t.Errorf("len: got=%d, want=%d", got, want)
+	}
+	var got []string
+	for _, t := range e.SortedTerms() {
+		got = append(got, t.Exp().String())
+	}
+	if want := "x^3,a^2,-2*a*b,b,3"; strings.Join(got, ",") != want {
+		t.Errorf("sorted: got=%q, want=%q", strings.Join(got, ","), want)
+	}
+	ts := e.SortedTerms()
+	ts[0].Coeff.SetInt64(7)
+	if got, want := e.String(), "3-2*a*b+a^2+b+x^3"; got != want {
+		t.Errorf("modified: got=%q, want=%q", got, want)
+	}
+	var z *Exp
+	if z.Len() != 0 || len(z.SortedTerms()) != 0 {
+		t.Error("nil expression has terms")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	vs := []struct {
+		e       string
+		pattern []f.Value
+		coeff   string
+		rest    string
+		found   bool
+	}{
+		{"a*x^2+b*x+c", []f.Value{f.S("x")}, "a*x+b", "c", true},
+		{"a*x^2+b*x+c", []f.Value{f.Sp("x", 2)}, "a", "b*x+c", true},
+		{"a*x^2+b*x+c", []f.Value{f.S("y")}, "0", "a*x^2+b*x+c", false},
+		{"x*y", []f.Value{f.S("x"), f.S("y")}, "1", "0", true},
+		{"0", []f.Value{f.S("x")}, "0", "0", false},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.e)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.e, err)
+		}
+		coeff, rest, found := e.Match(v.pattern)
+		if found != v.found {
+			t.Errorf("[%d] found: got=%v, want=%v", i, found, v.found)
+		}
+		if got := coeff.String(); got != v.coeff {
+			t.Errorf("[%d] coeff: got=%q, want=%q", i, got, v.coeff)
+		}
+		if got := rest.String(); got != v.rest {
+			t.Errorf("[%d] rest: got=%q, want=%q", i, got, v.rest)
+		}
+		div, rem := e.Partition(v.pattern)
+		if !Sum(div).Equals(coeff) || !Sum(rem).Equals(rest) {
+			t.Errorf("[%d] differs from partition: %v, %v", i, div, rem)
+		}
+	}
+}
+
+func TestAliasing(t *testing.T) {
+	e, err := ParseExp("a^3*b+a*c^2-2*a")
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	c, err := ParseExp("x*y+2*z")
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	want, cWant := e.String(), c.String()
+	a := []f.Value{f.S("a")}
+	for i := 0; i < 3; i++ {
+		e.Substitute(a, c)
+		e.Contains(a)
+		e.Partition(a)
+		e.Divide(c)
+		c.Divide(e)
+		if got := e.String(); got != want {
+			t.Fatalf("[%d] e modified: got=%q, want=%q", i, got, want)
+		}
+		if got := c.String(); got != cWant {
+			t.Fatalf("[%d] c modified: got=%q, want=%q", i, got, cWant)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	vs := []struct {
+		from, want string
+	}{
+		{"-beta^2 /-(alpha/beta)^-1", "alpha*beta"},
+		{"a/-(b-a)", "a/(a-b)"},
+		{"(1+x)/(1-x)", "(-1-x)/(-1+x)"},
+		{"(x+1)/(x^2-y)", "(1+x)/(x^2-y)"},
+		{"3/(-6*x)", "-1/(2*x)"},
+	}
+	for i, v := range vs {
+		r, _, err := ParseFrac(v.from)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		r.Normalize()
+		if got := r.String(); got != v.want {
+			t.Errorf("[%d] got=%q, want=%q", i, got, v.want)
+		}
+	}
+}
+
+func TestCoefficient(t *testing.T) {
+	e, err := ParseExp("3*a^2*b-1/2*b*a+7-b^-1")
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	vs := []struct {
+		fact []f.Value
+		want string
+	}{
+		{[]f.Value{f.Sp("a", 2), f.S("b")}, "3/1"},
+		{[]f.Value{f.S("b"), f.Sp("a", 2)}, "3/1"},
+		{[]f.Value{f.S("b"), f.S("a")}, "-1/2"},
+		{[]f.Value{f.D(2, 1), f.S("a"), f.S("b")}, "-1/4"},
+		{nil, "7/1"},
+		{[]f.Value{f.Sp("b", -1)}, "-1/1"},
+		{[]f.Value{f.S("a")}, "0/1"},
+		{[]f.Value{f.D(0, 1)}, "0/1"},
+	}
+	for i, v := range vs {
+		if got := e.Coefficient(v.fact).String(); got != v.want {
+			t.Errorf("[%d] %v: got=%q, want=%q", i, f.Prod(v.fact...), got, v.want)
+		}
+	}
+}
+
+func TestStringWith(t *testing.T) {
+	e, err := ParseExp("3*a*b^2-x^-1*y+1/2*z^(1/2)")
+	if err != nil {
+		t.Fatalf("failed to parse: %v", err)
+	}
+	vs := []struct {
+		opts f.FormatOptions
+		want string
+	}{
+		{want: e.String()},
+		{opts: f.FormatOptions{Sep: " "}, want: "3 a b^2-x^-1 y+1/2 z^(1/2)"},
+		{opts: f.FormatOptions{Sep: "·", Superscript: true}, want: "3·a·b²-x⁻¹·y+1/2·z^(1/2)"},
+	}
+	for i, v := range vs {
+		if got := e.StringWith(v.opts); got != v.want {
+			t.Errorf("[%d] got=%q want=%q", i, got, v.want)
+		}
+	}
+	if got := (&Exp{}).StringWith(f.FormatOptions{Sep: " "}); got != "0" {
+		t.Errorf("zero: got=%q want=\"0\"", got)
+	}
+}
+
+func TestFloatCoeffs(t *testing.T) {
+	vs := []struct {
+		from string
+		want []float64
+		min  int
+	}{
+		{"3*x^2-1/2*x+7", []float64{7, -0.5, 3}, 0},
+		{"x^3", []float64{0, 0, 0, 1}, 0},
+		{"2", []float64{2}, 0},
+		{"0", []float64{0}, 0},
+		{"x-x^-2", []float64{-1, 0, 0, 1}, -2},
+		{"1/3*x", []float64{0, 1.0 / 3}, 0},
+	}
+	for i, v := range vs {
+		e, err := ParseExp(v.from)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.from, err)
+		}
+		fs, min, err := e.FloatCoeffs(f.S("x"))
+		if err != nil {
+			t.Errorf("[%d] %q failed: %v", i, v.from, err)
+			continue
+		}
+		if got, want := fmt.Sprint(fs, min), fmt.Sprint(v.want, v.min); got != want {
+			t.Errorf("[%d] %q: got=%s want=%s", i, v.from, got, want)
+		}
+	}
+	for i, s := range []string{"x+y", "a*x^2", "x^(1/2)"} {
+		e, err := ParseExp(s)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, s, err)
+		}
+		if fs, _, err := e.FloatCoeffs(f.S("x")); err == nil {
+			t.Errorf("[%d] %q should fail: got=%v", i, s, fs)
+		}
+	}
+}
+
+func TestChebyshev(t *testing.T) {
+	vs := []struct {
+		kind, n int
+		want    string
+	}{
+		{1, 0, "1"},
+		{1, 1, "x"},
+		{1, 2, "-1+2*x^2"},
+		{1, 3, "-3*x+4*x^3"},
+		{1, 4, "1-8*x^2+8*x^4"},
+		{2, 0, "1"},
+		{2, 1, "2*x"},
+		{2, 2, "-1+4*x^2"},
+		{2, 3, "-4*x+8*x^3"},
+	}
+	for i, v := range vs {
+		e, err := Chebyshev(v.kind, v.n, f.S("x"))
+		if err != nil {
+			t.Errorf("[%d] failed: %v", i, err)
+			continue
+		}
+		if got := e.String(); got != v.want {
+			t.Errorf("[%d] kind=%d n=%d: got=%q, want=%q", i, v.kind, v.n, got, v.want)
+		}
+	}
+	// cos(3*t) = 4*cos(t)^3 - 3*cos(t).
+	e, _ := Chebyshev(1, 3, f.S("ct"))
+	got, err := NewFrac(e).EvalFloat(map[string]float64{"ct": math.Cos(0.4)})
+	if err != nil {
+		t.Fatalf("evaluating %v failed: %v", e, err)
+	}
+	if want := math.Cos(1.2); math.Abs(got-want) > 1e-12 {
+		t.Errorf("T3(cos(0.4)): got=%g, want=%g", got, want)
+	}
+	for _, v := range [][2]int{{0, 2}, {3, 2}, {1, -1}} {
+		if e, err := Chebyshev(v[0], v[1], f.S("x")); err == nil {
+			t.Errorf("kind=%d n=%d should fail: got=%v", v[0], v[1], e)
+		}
+	}
+}
+
+func TestMultipleAngle(t *testing.T) {
+	vs := []struct {
+		trig string
+		n    int
+		want string
+	}{
+		{"c", 2, "-1+2*ct^2"},
+		{"s", 2, "2*ct*st"},
+		{"c", 3, "-3*ct+4*ct^3"},
+		{"s", 3, "4*ct^2*st-st"},
+		{"c", 1, "ct"},
+		{"s", 1, "st"},
+		{"c", 0, "1"},
+		{"s", 0, "0"},
+		{"c", -2, "-1+2*ct^2"},
+		{"s", -2, "-2*ct*st"},
+	}
+	for i, v := range vs {
+		e, err := MultipleAngle(v.trig, v.n, "t")
+		if err != nil {
+			t.Errorf("[%d] failed: %v", i, err)
+			continue
+		}
+		if got := e.String(); got != v.want {
+			t.Errorf("[%d] %s%dt: got=%q, want=%q", i, v.trig, v.n, got, v.want)
+		}
+		const theta = 0.7
+		got, err := NewFrac(e).EvalFloat(map[string]float64{"ct": math.Cos(theta), "st": math.Sin(theta)})
+		if err != nil {
+			t.Errorf("[%d] evaluating %v failed: %v", i, e, err)
+			continue
+		}
+		want := math.Cos(float64(v.n) * theta)
+		if v.trig == "s" {
+			want = math.Sin(float64(v.n) * theta)
+		}
+		if math.Abs(got-want) > 1e-12 {
+			t.Errorf("[%d] %s%dt(%g): got=%g, want=%g", i, v.trig, v.n, theta, got, want)
+		}
+	}
+	if e, err := MultipleAngle("t", 2, "t"); err == nil {
+		t.Errorf("tangent expansion should fail: got=%v", e)
+	}
+}
+
+func TestBinomial(t *testing.T) {
+	vs := []struct {
+		a, b string
+		n    int
+		want string
+	}{
+		{"x", "1", 4, "1+4*x+6*x^2+4*x^3+x^4"},
+		{"x", "-y", 3, "3*x*y^2-3*x^2*y+x^3-y^3"},
+		{"a+b", "c", 2, "2*a*b+2*a*c+a^2+2*b*c+b^2+c^2"},
+		{"x", "y", 0, "1"},
+		{"x", "0", 2, "x^2"},
+	}
+	for i, v := range vs {
+		a, err := ParseExp(v.a)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.a, err)
+		}
+		b, err := ParseExp(v.b)
+		if err != nil {
+			t.Fatalf("[%d] failed to parse %q: %v", i, v.b, err)
+		}
+		e, err := Binomial(a, b, v.n)
+		if err != nil {
+			t.Errorf("[%d] binomial failed: %v", i, err)
+			continue
+		}
+		if got := e.String(); got != v.want {
+			t.Errorf("[%d] got=%q, want=%q", i, got, v.want)
+		}
+		if p, _ := Sum(a, b).Pow(v.n); !p.Equals(e) {
+			t.Errorf("[%d] binomial %v != power %v", i, e, p)
+		}
+	}
+	if _, err := Binomial(NewExp(one), NewExp(one), -1); err == nil {
+		t.Error("negative power accepted")
+	}
+}
+
+// binomialArgs returns multi-term expressions for benchmarking.
+func binomialArgs(b *testing.B) (*Exp, *Exp) {
+	x, err := ParseExp("x+2*y-z")
+	if err != nil {
+		b.Fatalf("failed to parse: %v", err)
+	}
+	y, err := ParseExp("a*b-3*c")
+	if err != nil {
+		b.Fatalf("failed to parse: %v", err)
+	}
+	return x, y
+}
+
+func BenchmarkBinomial(b *testing.B) {
+	x, y := binomialArgs(b)
+	for i := 0; i < b.N; i++ {
+		Binomial(x, y, 8)
+	}
+}
+
+func BenchmarkBinomialMul(b *testing.B) {
+	x, y := binomialArgs(b)
+	for i := 0; i < b.N; i++ {
+		Sum(x, y).Pow(8)
+	}
+}
+
+// sparse returns a 50-term expression in the symbols x and y.
+func sparse(y string) *Exp {
+	var es []*Exp
+	for i := 1; i <= 50; i++ {
+		es = append(es, NewExp([]f.Value{f.D(int64(i), 1), f.Sp("x", i), f.Sp(y, i%7)}))
+	}
+	return Sum(es...)
+}
+
+func BenchmarkMul(b *testing.B) {
+	x, y := sparse("y"), sparse("z")
+	for i := 0; i < b.N; i++ {
+		Mul(x, y)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	vs := []struct {
+		s   string
+		pos int
+	}{
+		{"", 0},
+		{"a+b+", 4},
+		{"a+b^^2", 4},
+		{"x*y z", 4},
+		{"2*x+y**", 7},
+		{"a+b+*c", 4},
+		{"+", 0},
+		{"-", 0},
+		{"a-", 1},
+		{"a - -", 4},
+		{"a/0", 2},
+	}
+	for i, v := range vs {
+		_, err := ParseExp(v.s)
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Errorf("[%d] %q: got=%v, want a *ParseError", i, v.s, err)
+			continue
+		}
+		if pe.Pos != v.pos {
+			t.Errorf("[%d] %q: got pos=%d, want=%d: %v", i, v.s, pe.Pos, v.pos, err)
+		}
+		if !errors.Is(err, f.ErrSyntax) {
+			t.Errorf("[%d] %q: %v is not %v", i, v.s, err, f.ErrSyntax)
+		}
+	}
+	// ParseFrac rewrites its input while parsing, but reports
+	// positions in the original text.
+	vs = []struct {
+		s   string
+		pos int
+	}{
+		{"x+y z", 4},
+		{"(a+b)*(c+)", 9},
+		{"f(x) + (a+b^^2)", 12},
+		{"a, b+", 5},
+		{"f(a, b+c*)", 9},
+		{"  g(x)   * h(y,  z^^2)", 19},
+		{"2^(1/2) * x y z", 12},
+		{"3^(2/3)+2**(3/2) + a+", 21},
+	}
+	for i, v := range vs {
+		_, _, err := ParseFrac(v.s)
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Errorf("[%d] %q: got=%v, want a *ParseError", i, v.s, err)
+			continue
+		}
+		if pe.Input != v.s || pe.Pos != v.pos {
+			t.Errorf("[%d] %q: got input=%q pos=%d, want pos=%d: %v", i, v.s, pe.Input, pe.Pos, v.pos, err)
+		}
+	}
+}
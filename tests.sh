@@ -6,17 +6,20 @@ if [ ${?} -ne 0 ]; then
     exit 1
 fi
 
-# Tests for the algex tool.
-go build examples/algex.go
+# Tests for the algex tool. These run in a temporary directory so
+# any files they write are cleaned up afterwards.
+TMPDIR=$(mktemp -d)
+go build -o "${TMPDIR}/algex" examples/algex.go
 if [ ${?} -ne 0 ]; then
     echo "FAILED"
+    rm -rf "${TMPDIR}"
     exit 1
 fi
-TMPDIR=$(mktemp -d)
+top=$(pwd)
 for t in tests/*.ax ; do
     echo "testing: $t"
     output="${TMPDIR}/${t#*/}.actual"
-    ./algex --file="$t" > "${output}"
+    (cd "${TMPDIR}" && ./algex --file="${top}/${t}") > "${output}"
     diff -u "${t}.ref" "${output}"
     x=${?}
     if [ ${x} -ne 0 ]; then